@@ -15,7 +15,15 @@ func cmdAdd() *clif.Command {
 		if err != nil {
 			return err
 		}
-		out.Printf("Adding repository <headline>%s<reset> as <subline>%s<reset>\n", abs, name)
+		entry := &common.Entry{Path: abs}
+		if workTree := c.Option("work-tree").String(); workTree != "" {
+			if entry.WorkTree, err = filepath.Abs(workTree); err != nil {
+				return err
+			}
+			out.Printf("Adding repository <headline>%s<reset> with work tree <headline>%s<reset> as <subline>%s<reset>\n", abs, entry.WorkTree, name)
+		} else {
+			out.Printf("Adding repository <headline>%s<reset> as <subline>%s<reset>\n", abs, name)
+		}
 		if p := lst.Get(name); p != "" {
 			out.Printf("<warn>There is a watch \"%s\" witch watches \"%s\"<reset>\n", name, p)
 			if !in.Confirm("<query>Overwrite?<reset> ") {
@@ -27,7 +35,7 @@ func cmdAdd() *clif.Command {
 		}
 		if n := lst.Watched(abs); n != "" {
 			return fmt.Errorf("Directory \"%s\" is already watched (%s)", abs, n)
-		} else if w, err := lst.Add(name, abs); err != nil {
+		} else if w, err := lst.AddEntry(name, entry); err != nil {
 			return err
 		} else {
 			out.Printf("  Type: <info>%s<reset>\n", w.Type())
@@ -37,7 +45,8 @@ func cmdAdd() *clif.Command {
 
 	return clif.NewCommand("add", "Add a new repository to the watch list", cb).
 		NewArgument("name", "Name of the repo, so you can remember what it was", "", true, false).
-		NewArgument("directory", "Path to directory of the repo. Defaults to current directory.", ".", true, false)
+		NewArgument("directory", "Path to directory of the repo. Defaults to current directory.", ".", true, false).
+		NewOption("work-tree", "w", "Path to separate work tree, if directory is a git dir (eg bare dotfiles repo)", "", false, false)
 
 }
 
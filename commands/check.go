@@ -1,11 +1,15 @@
 package commands
 
 import (
+	"context"
 	"github.com/ukautz/repos/common"
 	. "github.com/ukautz/repos/common/debug"
 	"gopkg.in/ukautz/clif.v1"
 	"sync"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
 func cmdCheck() *clif.Command {
@@ -16,14 +20,63 @@ func cmdCheck() *clif.Command {
 		} else if len(repos) == 0 {
 			out.Printf("<warn>No repos found<reset>\n")
 			return nil
+		} else if c.Option("worktrees").Bool() {
+			repos = expandWorktrees(repos, lst)
 		}
+		common.NoFetch = c.Option("no-fetch").Bool()
+		if ttl := c.Option("fetch-ttl").String(); ttl != "" {
+			if common.FetchTTL, err = time.ParseDuration(ttl); err != nil {
+				return fmt.Errorf("Invalid fetch TTL \"%s\": %s", ttl, err)
+			}
+		}
+		var timeout time.Duration
+		if value := c.Option("repo-timeout").String(); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil {
+				return fmt.Errorf("Invalid repo timeout \"%s\": %s", value, err)
+			}
+		}
+		jobs := c.Option("jobs").Int()
+		if jobs < 1 {
+			return fmt.Errorf("Invalid jobs %d, must be at least 1", jobs)
+		}
+		common.HostJobs = c.Option("host-jobs").Int()
+		started := time.Now()
+
+		// on interrupt running commands are killed and the results so far are
+		// printed
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		finished := make(chan bool)
+		defer close(finished)
+		c.Cli.SetOnInterrupt(func() error {
+			cancel()
+			<-finished
+			os.Exit(130)
+			return nil
+		})
 
 		// starting now
 		out.Printf("Checking <headline>%d<reset> repos\n", len(repos))
 		reposWithError := []*common.Info{}
 		reposWithLocalChanges := []*common.Info{}
-		reposAheadOfRemote := []*common.Info{}
-		reposBehindOfRemote := []*common.Info{}
+		localChanges := make(map[string]*common.ChangeSet)
+		branchesAheadOfRemote := [][]string{}
+		branchesBehindOfRemote := [][]string{}
+		branchesDivergedFromRemote := [][]string{}
+		branchesAheadOfReadOnlyRemote := [][]string{}
+		branchesNeverPublished := [][]string{}
+		branchesMissingOnRemote := [][]string{}
+		tagsNotPushed := [][]string{}
+		reposWithoutRemote := []*common.Info{}
+		reposWithUnsyncedAnnex := [][]string{}
+		reposWithSubmoduleChanges := [][]string{}
+		reposWithUnbackedCommits := [][]string{}
+		reposWithStashes := [][]string{}
+		reposWithOperation := [][]string{}
+		reposWithDetachedHead := [][]string{}
+		reposWithCachedRemote := [][]string{}
+		remotesUnverified := [][]string{}
+		interrupted := 0
 		var wg sync.WaitGroup
 		mux := new(sync.Mutex)
 		total := len(repos)
@@ -60,40 +113,214 @@ func cmdCheck() *clif.Command {
 		go func() {
 			defer close(progress)
 			var wgCheck sync.WaitGroup
-			for _, repo := range repos {
-				Debug(DEBUG1, "Checking repo %s", repo.Name)
-				wgCheck.Add(1)
-				go func(repo *common.Info) {
-					defer wgCheck.Done()
-					var add *[]*common.Info
-					if repo.Error != nil {
+			check := func(repo *common.Info) {
+				ctx, cancel := repoContext(ctx, timeout)
+				defer cancel()
+				bound := common.WithContext(ctx, repo.Repo)
+				var add *[]*common.Info
+				var changes *common.ChangeSet
+				var unsynced []*common.SyncState
+				var cached []string
+				noRemote := false
+				if repo.Error != nil {
+					add = &reposWithError
+				} else if set, err := common.ChangeSetContext(ctx, repo.Repo); err != nil {
+					repo.Error = err
+					add = &reposWithError
+				} else if changes = set.Without(repo.Ignore.Has(common.IGNORE_UNTRACKED), repo.IgnorePaths); !changes.Empty() {
+					add = &reposWithLocalChanges
+				} else if repo.Linked {
+					// branches, tags and remotes are checked with the repo
+				} else if states, err := common.StatesContext(ctx, repo.Repo); err == common.ErrOffline {
+					cached = []string{repo.Name, "not checked", repo.Path}
+				} else if err != nil {
+					repo.Error = err
+					add = &reposWithError
+				} else if synced, err := common.SyncedStates(states); err == common.ErrOffline {
+					cached = []string{repo.Name, "not checked", repo.Path}
+				} else if err != nil {
+					repo.Error = err
+					add = &reposWithError
+				} else if synced == common.SYNC_STATE_NO_REMOTE {
+					noRemote = true
+					if !repo.Ignore.Has(common.IGNORE_NO_REMOTE) {
+						add = &reposWithoutRemote
+					}
+				} else {
+					unsynced = states
+				}
+				if fetched, ok := bound.(common.Fetched); ok && !repo.Linked && cached == nil && (common.NoFetch || common.FetchTTL > 0) {
+					if last, err := fetched.LastFetch(); err != nil {
+						Debug(DEBUG1, "Failed to get last fetch of %s: %s", repo.Name, err)
+					} else if last.Before(started) {
+						cached = []string{repo.Name, formatAge(last), repo.Path}
+					}
+				}
+				var annex *common.AnnexState
+				if annexed, ok := bound.(common.Annexed); ok && !repo.Linked && repo.Error == nil {
+					var err error
+					if annex, err = annexed.Annex(); err != nil {
+						repo.Error = fmt.Errorf("Failed to check annex: %s", err)
 						add = &reposWithError
-					} else if changes, err := repo.Repo.Changes(); err != nil {
-						repo.Error = err
+					}
+				}
+				submodules := [][]string{}
+				if submoduled, ok := bound.(common.Submoduled); ok && !repo.Linked && repo.Error == nil {
+					if subs, err := submoduled.Submodules(); err != nil {
+						repo.Error = fmt.Errorf("Failed to check submodules: %s", err)
 						add = &reposWithError
-					} else if changes {
-						add = &reposWithLocalChanges
-					} else if synced, err := repo.Repo.Synced(); err != nil {
-						repo.Error = err
+					} else {
+						for _, sub := range subs {
+							problems := []string{}
+							if sub.Dirty {
+								problems = append(problems, "uncommitted changes")
+							}
+							if sub.Unpushed {
+								problems = append(problems, fmt.Sprintf("commit %.7s not pushed", sub.Commit))
+							}
+							if sub.Error != nil {
+								problems = append(problems, sub.Error.Error())
+							}
+							if len(problems) > 0 {
+								submodules = append(submodules, []string{repo.Name, sub.Path, strings.Join(problems, ", ")})
+							}
+						}
+					}
+				}
+				var unbacked []string
+				if unbackable, ok := bound.(common.Unbacked); ok && !repo.Linked && repo.Error == nil && !noRemote {
+					if count, branches, err := unbackable.Unbacked(); err != nil {
+						repo.Error = fmt.Errorf("Failed to check unbacked commits: %s", err)
 						add = &reposWithError
-					} else if synced == common.SYNC_STATE_AHEAD {
-						add = &reposAheadOfRemote
-					} else if synced == common.SYNC_STATE_BEHIND {
-						add = &reposBehindOfRemote
+					} else if count > 0 {
+						unbacked = []string{repo.Name, fmt.Sprintf("%d", count), strings.Join(branches, ", "), repo.Path}
 					}
+				}
+				var operation, detached []string
+				if headed, ok := bound.(common.Headed); ok && repo.Error == nil {
+					if head, err := headed.Head(); err != nil {
+						repo.Error = fmt.Errorf("Failed to check HEAD: %s", err)
+						add = &reposWithError
+					} else if head.Operation != "" {
+						operation = []string{repo.Name, head.Operation, repo.Path}
+					} else if head.Detached && head.Unreachable > 0 {
+						detached = []string{repo.Name, fmt.Sprintf("%d", head.Unreachable), repo.Path}
+					}
+				}
+				stashes := [][]string{}
+				if stashed, ok := bound.(common.Stashed); ok && !repo.Linked && repo.Error == nil && !repo.Ignore.Has(common.IGNORE_STASH) {
+					if list, err := stashed.Stashes(); err != nil {
+						repo.Error = fmt.Errorf("Failed to check stashes: %s", err)
+						add = &reposWithError
+					} else {
+						for _, stash := range list {
+							stashes = append(stashes, []string{repo.Name, stash.Name, formatAge(stash.Time), stash.Message, repo.Path})
+						}
+					}
+				}
+				if err := ctx.Err(); err == context.Canceled {
 					mux.Lock()
 					defer mux.Unlock()
-					if add != nil {
-						*add = append(*add, repo)
-						count++
-						Debug(DEBUG1, "Done: Repo %s changed (%d of %d)", repo.Name, count, total)
+					interrupted++
+					count++
+					Debug(DEBUG1, "Done: Repo %s interrupted (%d of %d)", repo.Name, count, total)
+					progress <- repo.Name
+					return
+				} else if err == context.DeadlineExceeded {
+					repo.Error = fmt.Errorf("Timed out after %s", timeout)
+					add = &reposWithError
+					unsynced, cached, annex, submodules, unbacked, stashes = nil, nil, nil, nil, nil, nil
+					operation, detached = nil, nil
+				}
+				mux.Lock()
+				defer mux.Unlock()
+				if add == &reposWithLocalChanges {
+					localChanges[repo.Name] = changes
+				}
+				unverified := make(map[string]bool)
+				for _, state := range unsynced {
+					var branches *[][]string
+					if state.State == common.SYNC_STATE_AHEAD {
+						branches = &branchesAheadOfRemote
+					} else if state.State == common.SYNC_STATE_BEHIND {
+						branches = &branchesBehindOfRemote
+					} else if state.State == common.SYNC_STATE_DIVERGED {
+						branches = &branchesDivergedFromRemote
+					} else if state.State == common.SYNC_STATE_AHEAD_READ_ONLY {
+						branches = &branchesAheadOfReadOnlyRemote
+					} else if state.State == common.SYNC_STATE_UNVERIFIED {
+						if !unverified[state.Remote] {
+							unverified[state.Remote] = true
+							reason := state.String()
+							if err, ok := state.Error.(*common.RemoteError); ok {
+								reason = err.Reason.String()
+							}
+							remotesUnverified = append(remotesUnverified, []string{repo.Name, state.Remote, reason, repo.Path})
+						}
+						continue
+					} else if state.State == common.SYNC_STATE_TAG_MISSING || state.State == common.SYNC_STATE_TAG_DIFFERENT {
+						tagsNotPushed = append(tagsNotPushed, []string{repo.Name, state.Tag, state.Remote, state.String(), repo.Path})
+						continue
+					} else if state.State == common.SYNC_STATE_UNTRACKED {
+						branchesNeverPublished = append(branchesNeverPublished, []string{repo.Name, state.Branch, repo.Path})
+						continue
+					} else if state.State == common.SYNC_STATE_MISSING {
+						branchesMissingOnRemote = append(branchesMissingOnRemote, []string{repo.Name, syncStateBranch(state), repo.Path})
+						continue
 					} else {
-						count++
-						Debug(DEBUG1, "Done: Repo %s unchanged (%d of %d)", repo.Name, count, total)
+						continue
 					}
-					progress <- repo.Name
-				}(repo)
+					*branches = append(*branches, []string{repo.Name, syncStateBranch(state), state.String(), repo.Path})
+				}
+				if cached != nil {
+					reposWithCachedRemote = append(reposWithCachedRemote, cached)
+				}
+				if unbacked != nil {
+					reposWithUnbackedCommits = append(reposWithUnbackedCommits, unbacked)
+				}
+				reposWithSubmoduleChanges = append(reposWithSubmoduleChanges, submodules...)
+				reposWithStashes = append(reposWithStashes, stashes...)
+				if operation != nil {
+					reposWithOperation = append(reposWithOperation, operation)
+				}
+				if detached != nil {
+					reposWithDetachedHead = append(reposWithDetachedHead, detached)
+				}
+				if annex != nil && (len(annex.Lacking) > 0 || annex.Unsynced) {
+					details := []string{}
+					if l := len(annex.Lacking); l > 0 {
+						details = append(details, fmt.Sprintf("%d files lacking copies", l))
+					}
+					if annex.Unsynced {
+						details = append(details, "annex branch not synced")
+					}
+					reposWithUnsyncedAnnex = append(reposWithUnsyncedAnnex, []string{repo.Name, repo.Path, strings.Join(details, ", ")})
+				}
+				if add != nil {
+					*add = append(*add, repo)
+					count++
+					Debug(DEBUG1, "Done: Repo %s changed (%d of %d)", repo.Name, count, total)
+				} else {
+					count++
+					Debug(DEBUG1, "Done: Repo %s unchanged (%d of %d)", repo.Name, count, total)
+				}
+				progress <- repo.Name
 			}
+			queue := make(chan *common.Info)
+			for i := 0; i < jobs && i < len(repos); i++ {
+				wgCheck.Add(1)
+				go func() {
+					defer wgCheck.Done()
+					for repo := range queue {
+						Debug(DEBUG1, "Checking repo %s", repo.Name)
+						check(repo)
+					}
+				}()
+			}
+			for _, repo := range repos {
+				queue <- repo
+			}
+			close(queue)
 			wgCheck.Wait()
 		}()
 		wg.Wait()
@@ -102,6 +329,9 @@ func cmdCheck() *clif.Command {
 		}
 
 		any := false
+		if interrupted > 0 {
+			out.Printf("\n<warn>Interrupted<reset>, %d of %d repos were not checked\n", interrupted, total)
+		}
 		if len(reposWithError) > 0 {
 			any = true
 			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>errors<reset>\n\n", len(reposWithError))
@@ -116,35 +346,157 @@ func cmdCheck() *clif.Command {
 			any = true
 			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>local changes<reset>\n", len(reposWithLocalChanges))
 			out.Printf("  <debug>Eg uncommited changes<reset>\n\n")
-			table := out.Table([]string{"Name", "Type", "Path"})
+			table := out.Table([]string{"Name", "Type", "Changes", "Path"})
 			for _, repo := range reposWithLocalChanges {
-				table.AddRow([]string{repo.Name, repo.Type, repo.Path})
+				table.AddRow([]string{repo.Name, repo.Type, localChanges[repo.Name].String(), repo.Path})
 				//out.Printf("  <info>%s<reset> (%s): <important>%s<reset>\n", repo.Name, repo.Type, repo.Path)
 			}
 			fmt.Println(table.Render())
+			if c.Option("show-files").Bool() {
+				for _, repo := range reposWithLocalChanges {
+					changes := localChanges[repo.Name]
+					if len(changes.Kinds()) == 0 {
+						continue
+					}
+					out.Printf("  <info>%s<reset>\n", repo.Name)
+					for _, kind := range changes.Kinds() {
+						for _, file := range changes.Paths(kind) {
+							out.Printf("    <debug>%-10s<reset> %s\n", kind, file)
+						}
+					}
+				}
+				fmt.Println()
+			}
 		}
-		if len(reposAheadOfRemote) > 0 {
+		if len(reposWithOperation) > 0 {
 			any = true
-			out.Printf("\n- - -\n\n Found <headline>%d<reset> which are <subline>ahead of local<reset>\n", len(reposAheadOfRemote))
-			out.Printf("  <debug>Eg remote has commits which are not merged into local<reset>\n\n")
-			table := out.Table([]string{"Name", "Type", "Path"})
-			for _, repo := range reposAheadOfRemote {
-				table.AddRow([]string{repo.Name, repo.Type, repo.Path})
-				//out.Printf("  <info>%s<reset> (%s): <important>%s<reset>\n", repo.Name, repo.Type, repo.Path)
-			}
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>unfinished operations<reset>\n", len(reposWithOperation))
+			out.Printf("  <debug>Eg a rebase, merge or bisect which was neither continued nor aborted<reset>\n\n")
+			table := out.Table([]string{"Name", "Operation", "Path"})
+			table.AddRows(reposWithOperation)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithDetachedHead) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>commits on detached HEAD<reset>\n", len(reposWithDetachedHead))
+			out.Printf("  <debug>Eg commits not on any branch, which are lost when checking out another branch<reset>\n\n")
+			table := out.Table([]string{"Name", "Commits", "Path"})
+			table.AddRows(reposWithDetachedHead)
 			fmt.Println(table.Render())
 		}
-		if len(reposBehindOfRemote) > 0 {
+		if len(branchesAheadOfRemote) > 0 {
 			any = true
-			out.Printf("\n- - -\n\n Found <headline>%d<reset> which are <subline>behind of local<reset>\n", len(reposBehindOfRemote))
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which are <subline>ahead of remote<reset>\n", len(branchesAheadOfRemote))
 			out.Printf("  <debug>Eg local has commits which are not merged with (at least one) remote<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Commits", "Path"})
+			table.AddRows(branchesAheadOfRemote)
+			fmt.Println(table.Render())
+		}
+		if len(branchesBehindOfRemote) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which are <subline>behind of remote<reset>\n", len(branchesBehindOfRemote))
+			out.Printf("  <debug>Eg remote has commits which are not merged into local<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Commits", "Path"})
+			table.AddRows(branchesBehindOfRemote)
+			fmt.Println(table.Render())
+		}
+		if len(branchesDivergedFromRemote) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which <subline>diverged from remote<reset>\n", len(branchesDivergedFromRemote))
+			out.Printf("  <debug>Eg local and remote both have commits the other has not, which need a merge or rebase<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Commits", "Path"})
+			table.AddRows(branchesDivergedFromRemote)
+			fmt.Println(table.Render())
+		}
+		if len(branchesAheadOfReadOnlyRemote) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which are <subline>ahead of read-only remote<reset>\n", len(branchesAheadOfReadOnlyRemote))
+			out.Printf("  <debug>Eg local commits which can not be pushed to the remote, so that a fork is needed<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Commits", "Path"})
+			table.AddRows(branchesAheadOfReadOnlyRemote)
+			fmt.Println(table.Render())
+		}
+		if len(branchesNeverPublished) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which were <subline>never published<reset>\n", len(branchesNeverPublished))
+			out.Printf("  <debug>Eg local branches without upstream, which do not exist on any remote<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Path"})
+			table.AddRows(branchesNeverPublished)
+			fmt.Println(table.Render())
+		}
+		if len(branchesMissingOnRemote) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> branches which are <subline>missing on remote<reset>\n", len(branchesMissingOnRemote))
+			out.Printf("  <debug>Eg Mercurial bookmarks not on the default path or branches whose upstream branch was deleted<reset>\n\n")
+			table := out.Table([]string{"Name", "Branch", "Path"})
+			table.AddRows(branchesMissingOnRemote)
+			fmt.Println(table.Render())
+		}
+		if len(tagsNotPushed) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> <subline>tags not pushed<reset>\n", len(tagsNotPushed))
+			out.Printf("  <debug>Eg local tags which are missing on the remote or point to another commit, see \"git push --tags\"<reset>\n\n")
+			table := out.Table([]string{"Name", "Tag", "Remote", "State", "Path"})
+			table.AddRows(tagsNotPushed)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithoutRemote) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> <subline>without any remote<reset>\n", len(reposWithoutRemote))
+			out.Printf("  <debug>Eg commits exist only locally and have no backup. Use \"ignore <name> %s\" for scratch repos<reset>\n\n", common.IGNORE_NO_REMOTE)
 			table := out.Table([]string{"Name", "Type", "Path"})
-			for _, repo := range reposBehindOfRemote {
+			for _, repo := range reposWithoutRemote {
 				table.AddRow([]string{repo.Name, repo.Type, repo.Path})
-				//out.Printf("  <info>%s<reset> (%s): <important>%s<reset>\n", repo.Name, repo.Type, repo.Path)
 			}
 			fmt.Println(table.Render())
 		}
+		if len(reposWithUnsyncedAnnex) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>unsynced annexed content<reset>\n", len(reposWithUnsyncedAnnex))
+			out.Printf("  <debug>Eg annexed files with fewer copies than numcopies<reset>\n\n")
+			table := out.Table([]string{"Name", "Path", "Annex"})
+			table.AddRows(reposWithUnsyncedAnnex)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithSubmoduleChanges) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> <subline>submodules with local changes<reset>\n", len(reposWithSubmoduleChanges))
+			out.Printf("  <debug>Eg uncommitted changes or referenced commits which are not pushed<reset>\n\n")
+			table := out.Table([]string{"Name", "Submodule", "Problem"})
+			table.AddRows(reposWithSubmoduleChanges)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithUnbackedCommits) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>commits only on this disk<reset>\n", len(reposWithUnbackedCommits))
+			out.Printf("  <debug>Eg commits of local branches or tags which are not contained in any remote branch<reset>\n\n")
+			table := out.Table([]string{"Name", "Commits", "Branches", "Path"})
+			table.AddRows(reposWithUnbackedCommits)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithStashes) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> stashes with <subline>stashed work<reset>\n", len(reposWithStashes))
+			out.Printf("  <debug>Eg changes set aside, which are neither committed nor pushed. Use \"ignore <name> %s\" for deliberate stashes<reset>\n\n", common.IGNORE_STASH)
+			table := out.Table([]string{"Name", "Stash", "Stashed", "Message", "Path"})
+			table.AddRows(reposWithStashes)
+			fmt.Println(table.Render())
+		}
+		if len(remotesUnverified) > 0 {
+			any = true
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> remotes which <subline>couldn't be verified<reset>\n", len(remotesUnverified))
+			out.Printf("  <debug>Eg fetching failed, because of missing credentials or network<reset>\n\n")
+			table := out.Table([]string{"Name", "Remote", "Reason", "Path"})
+			table.AddRows(remotesUnverified)
+			fmt.Println(table.Render())
+		}
+		if len(reposWithCachedRemote) > 0 {
+			out.Printf("\n- - -\n\n Found <headline>%d<reset> with <subline>cached remote state<reset>\n", len(reposWithCachedRemote))
+			out.Printf("  <debug>Eg remotes were not fetched now, so the above can be outdated<reset>\n\n")
+			table := out.Table([]string{"Name", "Fetched", "Path"})
+			table.AddRows(reposWithCachedRemote)
+			fmt.Println(table.Render())
+		}
 		if !any {
 			out.Printf(" <success>All is in sync!<reset>\n")
 		}
@@ -152,7 +504,37 @@ func cmdCheck() *clif.Command {
 		return nil
 	}
 
-	return addRepoFilterOptions(clif.NewCommand("check", "Check all registered repos", cb))
+	return addRepoFilterOptions(clif.NewCommand("check", "Check all registered repos", cb)).
+		NewFlag("worktrees", "w", "Check also all linked worktrees of the repos", false).
+		NewFlag("show-files", "f", "List the changed files of repos with local changes", false).
+		NewFlag("no-fetch", "n", "Do not fetch remotes, compare with the remote state of the last fetch", false).
+		NewOption("fetch-ttl", "t", "Do not fetch repos, which have been fetched within this duration, eg 15m", "", false, false).
+		NewOption("repo-timeout", "r", "Maximum duration of checking a single repo, eg 2m", "", false, false).
+		NewOption("jobs", "j", "Maximum of repos checked concurrently", "16", false, false).
+		NewOption("host-jobs", "H", "Maximum of concurrent fetches from the same remote host (0 = unlimited)", "4", false, false)
+}
+
+// repoContext returns the context of checking a single repo, which is done
+// after the timeout, if any
+func repoContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// syncStateBranch returns "remote/branch" name of the state, prefixed with the
+// local branch if the upstream branch has another name
+func syncStateBranch(state *common.SyncState) string {
+	if state.Upstream != "" {
+		return state.Branch + " -> " + state.Remote + "/" + state.Upstream
+	} else if state.Remote == "" {
+		return state.Branch
+	} else if state.Branch == "" {
+		return state.Remote
+	} else {
+		return state.Remote + "/" + state.Branch
+	}
 }
 
 func init() {
@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"fmt"
 	"gopkg.in/ukautz/clif.v1"
+	"path/filepath"
+	"time"
 )
 
 func addRepoFilterOptions(c *clif.Command) *clif.Command {
@@ -45,6 +47,62 @@ func reduceWithRepoFilters(c *clif.Command, allRepos []*common.Info) ([]*common.
 	return repos, nil
 }
 
+// expandWorktrees adds all linked worktrees of the given repos, which are not
+// registered by themselves. Only their work tree and HEAD are checked, since
+// everything else is shared with the repo
+func expandWorktrees(repos []*common.Info, lst *common.List) []*common.Info {
+	expanded := make([]*common.Info, 0)
+	for _, repo := range repos {
+		expanded = append(expanded, repo)
+		worktreed, ok := repo.Repo.(common.Worktrees)
+		if !ok {
+			continue
+		}
+		worktrees, err := worktreed.Worktrees()
+		if err != nil {
+			Debug(DEBUG1, "Failed to list worktrees of repo %s: %s", repo.Name, err)
+			continue
+		}
+		for _, path := range worktrees {
+			if lst.Watched(path) != "" {
+				Debug(DEBUG3, "Worktree %s of repo %s is registered by itself", path, repo.Name)
+				continue
+			}
+			info := &common.Info{
+				Name:   fmt.Sprintf("%s@%s", repo.Name, filepath.Base(path)),
+				Path:   path,
+				Linked: true,
+			}
+			if wt, err := common.NewRepo(path, info.Name); err != nil {
+				info.Type = "UNDEF"
+				info.Error = err
+			} else {
+				info.Type = wt.Type()
+				info.Repo = wt
+			}
+			expanded = append(expanded, info)
+		}
+	}
+	return expanded
+}
+
+// formatAge returns human readable age of a point in time, eg "3h ago"
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	age := time.Since(t)
+	if age < time.Minute {
+		return "just now"
+	} else if age < time.Hour {
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	} else if age < 48*time.Hour {
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	} else {
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
+
 func init() {
 	clif.DefaultTableStyle = clif.OpenTableStyleLight
 }
\ No newline at end of file
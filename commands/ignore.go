@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/ukautz/repos/common"
+	"gopkg.in/ukautz/clif.v1"
+	"path"
+	"sort"
+	"strings"
+)
+
+func cmdIgnore() *clif.Command {
+	cb := func(c *clif.Command, out clif.Output, lst *common.List) error {
+		name := c.Argument("name").String()
+		findings := c.Argument("finding").Strings()
+		patterns := c.Option("path").Strings()
+		unset := c.Option("unset").Bool()
+		entry := lst.Entry(name)
+		if entry == nil {
+			return fmt.Errorf("No repo with name \"%s\" found", name)
+		} else if len(findings) == 0 && len(patterns) == 0 {
+			return fmt.Errorf("Neither findings nor paths to ignore given")
+		}
+		for _, finding := range findings {
+			if _, ok := common.Ignorables[finding]; !ok {
+				return fmt.Errorf("Cannot ignore unknown finding \"%s\"", finding)
+			}
+			entry.Ignore = entry.Ignore.Set(finding, !unset)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid path pattern \"%s\": %s", pattern, err)
+			}
+			entry.IgnorePaths = []string(common.Ignore(entry.IgnorePaths).Set(pattern, !unset))
+		}
+		if len(entry.IgnorePaths) == 0 {
+			entry.IgnorePaths = nil
+		}
+		if err := lst.Persist(); err != nil {
+			return fmt.Errorf("Failed to persist repos: %s", err)
+		} else if len(entry.Ignore) == 0 && len(entry.IgnorePaths) == 0 {
+			out.Printf("Nothing ignored for <info>%s<reset>\n", name)
+		} else {
+			if len(entry.Ignore) > 0 {
+				out.Printf("Ignoring <subline>%s<reset> for <info>%s<reset>\n", strings.Join(entry.Ignore, ", "), name)
+			}
+			if len(entry.IgnorePaths) > 0 {
+				out.Printf("Ignoring changes of <subline>%s<reset> for <info>%s<reset>\n", strings.Join(entry.IgnorePaths, ", "), name)
+			}
+		}
+		return nil
+	}
+
+	findings := []string{}
+	for finding, desc := range common.Ignorables {
+		findings = append(findings, fmt.Sprintf("  %s: %s", finding, desc))
+	}
+	sort.Strings(findings)
+
+	return clif.NewCommand("ignore", "Ignore findings of a registered repo in check", cb).
+		SetDescription(strings.Join(append([]string{
+			"Ignore findings of a registered repo, which are intentional. Findings are:",
+			"",
+		}, append(findings,
+			"",
+			"Changes of files matching a path pattern (--path) are not reported as local changes.",
+		)...), "\n")).
+		NewArgument("name", "Name of the repo", "", true, false).
+		NewArgument("finding", "Findings to ignore", "", false, true).
+		NewOption("path", "p", "Ignore changes of files matching the pattern, eg \"*.log\" or \"docs/*.pdf\"", "", false, true).
+		NewFlag("unset", "u", "Report the findings or paths again", false)
+}
+
+func init() {
+	Commands = append(Commands, cmdIgnore)
+}
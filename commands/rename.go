@@ -10,14 +10,14 @@ func cmdRename() *clif.Command {
 	cb := func(c *clif.Command, out clif.Output, lst *common.List) error {
 		oldName := c.Argument("old-name").String()
 		newName := c.Argument("new-name").String()
-		if path := lst.Get(oldName); path == "" {
-			return fmt.Errorf("No repo with name \"%s\" found")
+		if entry := lst.Entry(oldName); entry == nil {
+			return fmt.Errorf("No repo with name \"%s\" found", oldName)
 		} else if existingPath := lst.Get(newName); existingPath != "" {
 			return fmt.Errorf("Repo with name \"%s\" already exists: %s", newName, existingPath)
 		} else {
 			lst.Remove(oldName)
-			if _, err := lst.Add(newName, path); err != nil {
-				return fmt.Errorf("Failed to re-add repo in \"%s\" under new name %s: %s", path, newName, err)
+			if _, err := lst.AddEntry(newName, entry); err != nil {
+				return fmt.Errorf("Failed to re-add repo in \"%s\" under new name %s: %s", entry.Path, newName, err)
 			} else if err = lst.Persist(); err != nil {
 				return fmt.Errorf("Failed to persist repos: %s", err)
 			}
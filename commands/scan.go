@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"github.com/ukautz/repos/common"
 	"gopkg.in/ukautz/clif.v1"
@@ -10,12 +11,17 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-func scan(dir string, dirs chan<- string, out clif.Output, maxDepth, depth int) error {
+func scan(ctx context.Context, dir string, dirs chan<- string, out clif.Output, maxDepth, depth int) error {
 	//defer out.Printf("-1 %s\n", dir)
 	//out.Printf("+1 %s\n", dir)
-	dirs <- dir
+	select {
+	case dirs <- dir:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	var wg sync.WaitGroup
 	if fhs, err := ioutil.ReadDir(dir); err != nil {
 		return err
@@ -27,7 +33,7 @@ func scan(dir string, dirs chan<- string, out clif.Output, maxDepth, depth int)
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						scan(abs, dirs, out, maxDepth, depth+1)
+						scan(ctx, abs, dirs, out, maxDepth, depth+1)
 					}()
 				}
 			}
@@ -44,20 +50,32 @@ func cmdScan() *clif.Command {
 		if excludeStr := c.Option("exclude").String(); excludeStr != "" {
 			exclude = regexp.MustCompile(excludeStr)
 		}
-		added := 0
-		exit := false
+		var added int32
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 		mux := new(sync.Mutex)
-		c.Cli.SetOnInterrupt(func() error {
-			exit = true
-			out.Printf("\n")
-			if added > 0 {
-				mux.Unlock()
+
+		// either the interrupt handler or the aborted scan asks whether to
+		// persist, whichever is first
+		var abort sync.Once
+		aborted := func() {
+			abort.Do(func() {
+				out.Printf("\n")
+
+				// no repo is added after cancel, but one might be in progress
 				mux.Lock()
-				out.Printf("\n<important>Aborting<reset>. Just got one question:\n")
-				if in.Confirm(fmt.Sprintf("You've added %d repos. Shall I persist?", added)) {
-					lst.Persist()
+				defer mux.Unlock()
+				if added := atomic.LoadInt32(&added); added > 0 {
+					out.Printf("\n<important>Aborting<reset>. Just got one question:\n")
+					if in.Confirm(fmt.Sprintf("You've added %d repos. Shall I persist?", added)) {
+						lst.Persist()
+					}
 				}
-			}
+			})
+		}
+		c.Cli.SetOnInterrupt(func() error {
+			cancel()
+			aborted()
 			os.Exit(0)
 			return nil
 		})
@@ -76,17 +94,18 @@ func cmdScan() *clif.Command {
 		go func() {
 			defer wg.Done()
 			defer close(dirs)
-			scan(root, dirs, out, maxDepth, 0)
+			scan(ctx, root, dirs, out, maxDepth, 0)
 		}()
 
 		for dir := range dirs {
-			if exit {
-				break
-			}
-			if addRepo(lst, dir, prefix, include, exclude, out, in, mux) {
-				added++
+			if addRepo(ctx, lst, dir, prefix, include, exclude, out, in, mux) {
+				atomic.AddInt32(&added, 1)
 			}
 		}
+		if ctx.Err() != nil {
+			aborted()
+			return nil
+		}
 
 		return lst.Persist()
 	}
@@ -104,9 +123,7 @@ func cmdScan() *clif.Command {
 		NewOption("prefix", "p", "Prefix added to all suggested names", "", false, false)
 }
 
-func addRepo(lst *common.List, dir, prefix string, include, exclude *regexp.Regexp, out clif.Output, in clif.Input, mux *sync.Mutex) bool {
-	mux.Lock()
-	defer mux.Unlock()
+func addRepo(ctx context.Context, lst *common.List, dir, prefix string, include, exclude *regexp.Regexp, out clif.Output, in clif.Input, mux *sync.Mutex) bool {
 	if lst.Watched(dir) == "" {
 		out.Printf("Considering directory <info>%s<reset>\n", dir)
 		base := strings.ToLower(filepath.Base(dir))
@@ -146,7 +163,11 @@ func addRepo(lst *common.List, dir, prefix string, include, exclude *regexp.Rege
 				}
 			}
 		}
-		if _, err := lst.Add(name, dir); err != nil {
+		mux.Lock()
+		defer mux.Unlock()
+		if ctx.Err() != nil {
+			return false
+		} else if _, err := lst.Add(name, dir); err != nil {
 			out.Printf("  <error>Failed to add: %s<reset>\n\n", err)
 			return false
 		} else {
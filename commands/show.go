@@ -16,6 +16,9 @@ func cmdShow() *clif.Command {
 		errs := 0
 		for _, watch := range watches {
 			row := []string{watch.Name, watch.Type, watch.Path, ""}
+			if watch.WorkTree != "" {
+				row[2] = fmt.Sprintf("%s (work tree: %s)", watch.Path, watch.WorkTree)
+			}
 			if watch.Error != nil {
 				errs ++
 				row[3] = watch.Error.Error()
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// Changed is implemented by repos, which can describe their local changes
+	// in detail
+	Changed interface {
+
+		// ChangeSet returns the uncommitted changes of the working tree
+		ChangeSet() (*ChangeSet, error)
+	}
+
+	// ChangeSet describes uncommitted changes by the paths of the changed
+	// files, relative to the repo. A file can have multiple kinds of
+	// changes, eg staged and modified again.
+	ChangeSet struct {
+
+		// Staged contains added or modified files, which are staged for commit
+		Staged []string
+
+		// Modified contains files with changes, which are not staged
+		Modified []string
+
+		// Untracked contains files, which are not versioned and not ignored
+		Untracked []string
+
+		// Deleted contains removed files, staged or not
+		Deleted []string
+
+		// Renamed contains staged renames as "old -> new"
+		Renamed []string
+
+		// Conflicted contains files with unresolved merge conflicts
+		Conflicted []string
+
+		// Unknown is whether there are changes, which the repo can not
+		// describe in detail
+		Unknown bool
+	}
+)
+
+// Empty returns whether there are no changes
+func (this *ChangeSet) Empty() bool {
+	return !this.Unknown && len(this.Kinds()) == 0
+}
+
+// Kinds returns the names of the kinds of changes, which occurred, in the
+// order of their relevance, eg "staged"
+func (this *ChangeSet) Kinds() []string {
+	kinds := []string{}
+	for _, kind := range changeKinds {
+		if len(*kind.paths(this)) > 0 {
+			kinds = append(kinds, kind.name)
+		}
+	}
+	return kinds
+}
+
+// Paths returns the changed files of a kind of changes
+func (this *ChangeSet) Paths(kind string) []string {
+	for _, k := range changeKinds {
+		if k.name == kind {
+			return *k.paths(this)
+		}
+	}
+	return nil
+}
+
+// String returns summary of the changes, eg "2 staged, 14 untracked"
+func (this *ChangeSet) String() string {
+	parts := []string{}
+	for _, kind := range changeKinds {
+		if l := len(*kind.paths(this)); l > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", l, kind.name))
+		}
+	}
+	if this.Unknown {
+		parts = append(parts, "local changes")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Without returns copy of the change set without untracked files, if
+// untracked is set, and without files matching any of the patterns. See
+// matchPath for patterns.
+func (this *ChangeSet) Without(untracked bool, patterns []string) *ChangeSet {
+	without := &ChangeSet{Unknown: this.Unknown}
+	for _, kind := range changeKinds {
+		if untracked && kind.name == "untracked" {
+			continue
+		}
+		paths := kind.paths(without)
+		for _, file := range *kind.paths(this) {
+			if !matchAnyPath(patterns, file) {
+				*paths = append(*paths, file)
+			}
+		}
+	}
+	return without
+}
+
+// add records a file by its two letter status of the index (x) and the
+// working tree (y) as printed by "git status --porcelain". The original
+// path is only given for renames and copies.
+func (this *ChangeSet) add(x, y byte, file, orig string) {
+	if x == '?' {
+		this.Untracked = append(this.Untracked, file)
+		return
+	} else if x == 'U' || y == 'U' || (x == y && (x == 'A' || x == 'D')) {
+		this.Conflicted = append(this.Conflicted, file)
+		return
+	}
+	if x == 'R' || x == 'C' {
+		this.Renamed = append(this.Renamed, orig+" -> "+file)
+	} else if x == 'D' {
+		this.Deleted = append(this.Deleted, file)
+	} else if x == 'M' || x == 'A' || x == 'T' {
+		this.Staged = append(this.Staged, file)
+	}
+	if y == 'D' {
+		this.Deleted = append(this.Deleted, file)
+	} else if y == 'M' || y == 'A' || y == 'T' {
+		this.Modified = append(this.Modified, file)
+	}
+}
+
+// sort orders the paths of all kinds of changes
+func (this *ChangeSet) sort() {
+	for _, kind := range changeKinds {
+		sort.Strings(*kind.paths(this))
+	}
+}
+
+// changeKinds lists the kinds of changes in the order of their relevance
+var changeKinds = []struct {
+	name  string
+	paths func(*ChangeSet) *[]string
+}{
+	{"conflicted", func(c *ChangeSet) *[]string { return &c.Conflicted }},
+	{"staged", func(c *ChangeSet) *[]string { return &c.Staged }},
+	{"modified", func(c *ChangeSet) *[]string { return &c.Modified }},
+	{"deleted", func(c *ChangeSet) *[]string { return &c.Deleted }},
+	{"renamed", func(c *ChangeSet) *[]string { return &c.Renamed }},
+	{"untracked", func(c *ChangeSet) *[]string { return &c.Untracked }},
+}
+
+// matchPath checks whether a path relative to the repo matches the pattern.
+// Patterns with a slash are matched against the whole path, eg "docs/*.pdf",
+// other patterns against each path element, eg "*.log". Matching directories
+// match all files below, eg "vendor".
+func matchPath(pattern, file string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	file = strings.TrimSuffix(file, "/")
+	if i := strings.Index(file, " -> "); i > -1 {
+		return matchPath(pattern, file[:i]) && matchPath(pattern, file[i+4:])
+	}
+	elements := strings.Split(file, "/")
+	for i := range elements {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, strings.Join(elements[:i+1], "/")); ok {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern, elements[i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyPath checks whether the path matches any of the patterns
+func matchAnyPath(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeSetContext returns the local changes of the repo, when the context is
+// not done before. Changes of repos, which are not Changed, are Unknown.
+func ChangeSetContext(ctx context.Context, repo Repo) (*ChangeSet, error) {
+	changes := &ChangeSet{}
+	if err := runContext(ctx, func() (err error) {
+		bound := WithContext(ctx, repo)
+		if changed, ok := bound.(Changed); ok {
+			var set *ChangeSet
+			if set, err = changed.ChangeSet(); err == nil {
+				changes = set
+			}
+		} else {
+			changes.Unknown, err = bound.Changes()
+		}
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
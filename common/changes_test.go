@@ -0,0 +1,82 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangeSetAdd(t *testing.T) {
+	tests := []struct {
+		status, file, orig string
+		expect             ChangeSet
+	}{
+		{"??", "new", "", ChangeSet{Untracked: []string{"new"}}},
+		{"M ", "a", "", ChangeSet{Staged: []string{"a"}}},
+		{" M", "a", "", ChangeSet{Modified: []string{"a"}}},
+		{"MM", "a", "", ChangeSet{Staged: []string{"a"}, Modified: []string{"a"}}},
+		{"A ", "a", "", ChangeSet{Staged: []string{"a"}}},
+		{"AM", "a", "", ChangeSet{Staged: []string{"a"}, Modified: []string{"a"}}},
+		{"T ", "a", "", ChangeSet{Staged: []string{"a"}}},
+		{" T", "a", "", ChangeSet{Modified: []string{"a"}}},
+		{"D ", "a", "", ChangeSet{Deleted: []string{"a"}}},
+		{" D", "a", "", ChangeSet{Deleted: []string{"a"}}},
+		{"MD", "a", "", ChangeSet{Staged: []string{"a"}, Deleted: []string{"a"}}},
+		{"R ", "new", "old", ChangeSet{Renamed: []string{"old -> new"}}},
+		{"RM", "new", "old", ChangeSet{Renamed: []string{"old -> new"}, Modified: []string{"new"}}},
+		{"C ", "copy", "orig", ChangeSet{Renamed: []string{"orig -> copy"}}},
+		{"UU", "a", "", ChangeSet{Conflicted: []string{"a"}}},
+		{"AA", "a", "", ChangeSet{Conflicted: []string{"a"}}},
+		{"DD", "a", "", ChangeSet{Conflicted: []string{"a"}}},
+		{"AU", "a", "", ChangeSet{Conflicted: []string{"a"}}},
+		{"UD", "a", "", ChangeSet{Conflicted: []string{"a"}}},
+	}
+	for _, test := range tests {
+		changes := &ChangeSet{}
+		changes.add(test.status[0], test.status[1], test.file, test.orig)
+		if !reflect.DeepEqual(*changes, test.expect) {
+			t.Errorf("Status \"%s\": expected %+v, got %+v", test.status, test.expect, *changes)
+		}
+	}
+}
+
+func TestChangeSetWithout(t *testing.T) {
+	changes := &ChangeSet{
+		Staged:    []string{"a.go", "debug.log"},
+		Untracked: []string{"tmp/x"},
+		Renamed:   []string{"a.log -> b.log"},
+	}
+	expect := &ChangeSet{Staged: []string{"a.go"}}
+	if without := changes.Without(true, []string{"*.log"}); !reflect.DeepEqual(without, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, without)
+	} else if s := changes.String(); s != "2 staged, 1 renamed, 1 untracked" {
+		t.Errorf("Unexpected summary \"%s\"", s)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, file string
+		expect        bool
+	}{
+		{"*.log", "a.log", true},
+		{"*.log", "dir/a.log", true},
+		{"*.log", "a.txt", false},
+		{"*.log", "a.log/b.txt", true},
+		{"docs/*.pdf", "docs/a.pdf", true},
+		{"docs/*.pdf", "src/docs/a.pdf", false},
+		{"docs/*.pdf", "docs/sub/a.pdf", false},
+		{"vendor", "vendor/x/y.go", true},
+		{"vendor/", "vendor/x", true},
+		{"vendor", "src/vendor/x", true},
+		{"vendor", "vendors/x", false},
+		{"build/out", "build/out/a", true},
+		{"build/out", "build/output", false},
+		{"*.log", "a.log -> b.log", true},
+		{"*.log", "a.log -> b.txt", false},
+	}
+	for _, test := range tests {
+		if match := matchPath(test.pattern, test.file); match != test.expect {
+			t.Errorf("Pattern \"%s\" on \"%s\": expected %v, got %v", test.pattern, test.file, test.expect, match)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Config contains the settings of the user, which are persisted as JSON file
+type Config struct {
+
+	// ReadOnly contains rules for remotes, which can not be pushed to. Rules
+	// are either hosts, eg "git.example.com", or URL patterns, in which "*"
+	// matches anything, eg "github.com/torvalds/*" or "https://*". Patterns
+	// are matched against the whole URL and against host and path of it.
+	ReadOnly []string `json:"read_only,omitempty"`
+}
+
+var (
+	// ReadOnlyRemotes contains the rules of read-only remotes. See
+	// Config.ReadOnly
+	ReadOnlyRemotes = []string{"git://*"}
+)
+
+// ReadConfig reads the config from a JSON file. A missing file is an empty
+// config.
+func ReadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if raw, err := ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	} else if err = json.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("Failed to parse config \"%s\": %s", path, err)
+	} else {
+		return config, nil
+	}
+}
+
+// readOnlyRemote checks whether any rule of ReadOnlyRemotes matches the URL
+func readOnlyRemote(remote string) bool {
+	host := remoteHost(remote)
+	location := remoteLocation(remote)
+	for _, rule := range ReadOnlyRemotes {
+		if !strings.ContainsAny(rule, "*/:") {
+			if host != "" && strings.EqualFold(rule, host) {
+				return true
+			}
+			continue
+		}
+		rx := regexp.MustCompile("(?i)^" + strings.Replace(regexp.QuoteMeta(rule), `\*`, ".*", -1) + "$")
+		if rx.MatchString(remote) || (location != "" && rx.MatchString(location)) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteLocation returns host and path of a remote URL, eg "github.com/a/b.git"
+// for "git@github.com:a/b.git", or an empty string for local paths
+func remoteLocation(remote string) string {
+	host := remoteHost(remote)
+	if host == "" {
+		return ""
+	} else if strings.Contains(remote, "://") {
+		if u, err := url.Parse(remote); err == nil {
+			return host + "/" + strings.TrimPrefix(u.Path, "/")
+		}
+		return ""
+	} else {
+		return host + "/" + strings.TrimPrefix(remote[strings.Index(remote, ":")+1:], "/")
+	}
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestReadOnlyRemote(t *testing.T) {
+	defer func(rules []string) {
+		ReadOnlyRemotes = rules
+	}(ReadOnlyRemotes)
+	ReadOnlyRemotes = []string{"git://*", "git.example.com", "github.com/torvalds/*", "https://*.internal/*"}
+	tests := []struct {
+		remote string
+		expect bool
+	}{
+		{"git@github.com:torvalds/linux.git", true},
+		{"https://github.com/torvalds/linux", true},
+		{"ssh://git@github.com/torvalds/linux", true},
+		{"https://GitHub.com/Torvalds/linux", true},
+		{"https://github.com/me/linux", false},
+		{"git://example.com/x.git", true},
+		{"https://git.example.com/x.git", true},
+		{"git@git.example.com:x.git", true},
+		{"https://example.com/x.git", false},
+		{"https://code.internal/x", true},
+		{"https://code.internal.example.com/x", false},
+		{"../local.git", false},
+		{"/srv/git/x.git", false},
+	}
+	for _, test := range tests {
+		if readOnly := readOnlyRemote(test.remote); readOnly != test.expect {
+			t.Errorf("Remote \"%s\": expected %v, got %v", test.remote, test.expect, readOnly)
+		}
+	}
+}
+
+func TestRemoteLocation(t *testing.T) {
+	tests := []struct {
+		remote, expect string
+	}{
+		{"git@github.com:a/b.git", "github.com/a/b.git"},
+		{"https://github.com/a/b.git", "github.com/a/b.git"},
+		{"ssh://git@github.com:22/a/b.git", "github.com/a/b.git"},
+		{"../local.git", ""},
+		{"/srv/git/x.git", ""},
+	}
+	for _, test := range tests {
+		if location := remoteLocation(test.remote); location != test.expect {
+			t.Errorf("Remote \"%s\": expected \"%s\", got \"%s\"", test.remote, test.expect, location)
+		}
+	}
+}
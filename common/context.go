@@ -0,0 +1,46 @@
+package common
+
+import (
+	"context"
+)
+
+// WithContext returns the repo bound to the context, if it is Contexted, or
+// the repo itself
+func WithContext(ctx context.Context, repo Repo) Repo {
+	if contexted, ok := repo.(Contexted); ok {
+		return contexted.WithContext(ctx)
+	}
+	return repo
+}
+
+// StatesContext is Repo.States, which returns when the context is done
+func StatesContext(ctx context.Context, repo Repo) ([]*SyncState, error) {
+	var states []*SyncState
+	if err := runContext(ctx, func() (err error) {
+		states, err = WithContext(ctx, repo).States()
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// runContext runs the callback and returns its error or the error of the
+// context, if it is done first. Repos which are not Contexted can not be
+// stopped, so their callback keeps running in the background and its results
+// must not be read after an error.
+func runContext(ctx context.Context, cb func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cb()
+	}()
+	select {
+	case err := <-done:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
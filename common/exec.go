@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/ukautz/repos/common/debug"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// CommandTimeout is the maximum duration of a single command, if set
+var CommandTimeout time.Duration
+
+// execError is returned by failed commands which wrote to STDERR
+type execError struct {
+	err    error
+	stderr string
+}
+
+func (this *execError) Error() string {
+	return fmt.Sprintf("%s (%s)", this.stderr, this.err)
+}
+
+// exitCode returns the exit code of a failed command or -1
+func exitCode(err error) int {
+	if e, ok := err.(*execError); ok {
+		err = e.err
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	return -1
+}
+
+// commandContext returns the context of a single command, which is done after
+// the CommandTimeout. The parent context may be nil.
+func commandContext(parent context.Context) (context.Context, context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if CommandTimeout > 0 {
+		ctx, cancel := context.WithTimeout(parent, CommandTimeout)
+		return parent, ctx, cancel
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return parent, ctx, cancel
+}
+
+// contextError returns why a command was killed, if its context is done: the
+// error of the parent context or the timeout of the command
+func contextError(parent, ctx context.Context, command string) error {
+	if err := parent.Err(); err != nil {
+		return err
+	} else if ctx.Err() != nil {
+		return fmt.Errorf("Command %s timed out after %s", command, CommandTimeout)
+	} else {
+		return nil
+	}
+}
+
+// localeEnv returns the environment, in which commands print untranslated
+// messages, which can be parsed independent of the user's locale. The
+// character set of the locale is kept, since commands like svn fail on
+// non-ASCII paths without. LC_ALL, which would override the messages, is
+// replaced by the character set it sets.
+func localeEnv(environ []string) []string {
+	env := make([]string, 0, len(environ)+3)
+	ctype := ""
+	for _, v := range environ {
+		if strings.Index(v, "LC_ALL=") == 0 {
+			ctype = v[len("LC_ALL="):]
+		} else {
+			env = append(env, v)
+		}
+	}
+	if ctype != "" {
+		env = append(env, "LC_CTYPE="+ctype)
+	}
+	return append(env, "LC_MESSAGES=C", "LANGUAGE=C")
+}
+
+// stderr returns the output to STDERR of a failed command
+func stderr(err error) string {
+	if e, ok := err.(*execError); ok {
+		return e.stderr
+	}
+	return ""
+}
+
+// execLines runs command in path and returns the non-empty lines printed to
+// STDOUT. Output to STDERR becomes part of the error, if the command fails.
+// The command is killed, when the context is done.
+func execLines(ctx context.Context, command, name, path string, env []string, args ...string) ([]string, error) {
+	return execLabeled(ctx, command, command, name, path, env, args...)
+}
+
+// execLabeled is execLines with a label naming the command in errors, eg
+// "git fetch"
+func execLabeled(ctx context.Context, label, command, name, path string, env []string, args ...string) ([]string, error) {
+	output, err := execOutput(ctx, label, command, name, path, env, args...)
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			lines = append(lines, line)
+			Debug(DEBUG3, " out: %s", line)
+		}
+	}
+	return lines, err
+}
+
+// execOutput is execLabeled, which returns the whole output to STDOUT, eg to
+// split output separated by NUL
+func execOutput(ctx context.Context, label, command, name, path string, env []string, args ...string) (string, error) {
+	Debug(DEBUG2, "%s exec [%s: %s]: %s", command, name, path, strings.Join(args, " "))
+	parent, ctx, cancel := commandContext(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, args...)
+	killGroup(cmd)
+	cmd.Dir = path
+	cmd.Env = append(localeEnv(os.Environ()), env...)
+	errOut := bytes.NewBuffer(nil)
+	stdOut := bytes.NewBuffer(nil)
+	cmd.Stderr = errOut
+	cmd.Stdout = stdOut
+	err := cmd.Run()
+	if err := contextError(parent, ctx, label); err != nil {
+		return "", err
+	}
+	if msg := strings.TrimSpace(errOut.String()); err != nil && msg != "" {
+		Debug(DEBUG3, " err: %s", msg)
+		return stdOut.String(), &execError{err, msg}
+	}
+	return stdOut.String(), err
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocaleEnv(t *testing.T) {
+	tests := []struct {
+		environ, expect []string
+	}{
+		{
+			[]string{"HOME=/home/a", "LANG=de_DE.UTF-8"},
+			[]string{"HOME=/home/a", "LANG=de_DE.UTF-8", "LC_MESSAGES=C", "LANGUAGE=C"},
+		},
+		{
+			[]string{"LC_ALL=de_DE.UTF-8", "LC_CTYPE=C"},
+			[]string{"LC_CTYPE=C", "LC_CTYPE=de_DE.UTF-8", "LC_MESSAGES=C", "LANGUAGE=C"},
+		},
+		{
+			[]string{"LC_ALL=", "LANGUAGE=de"},
+			[]string{"LANGUAGE=de", "LC_MESSAGES=C", "LANGUAGE=C"},
+		},
+	}
+	for _, test := range tests {
+		if env := localeEnv(test.environ); !reflect.DeepEqual(env, test.expect) {
+			t.Errorf("Environment %v: expected %v, got %v", test.environ, test.expect, env)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package common
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killGroup starts the command in its own session and process group, which
+// is killed as a whole when the context is done, so that children (eg ssh of
+// git) stop too. Without controlling terminal, children which try to prompt
+// fail instead of being stopped in the background.
+func killGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
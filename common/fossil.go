@@ -0,0 +1,124 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Fossil is a watch of a Fossil checkout
+type Fossil struct {
+	name string
+	path string
+	ctx  context.Context
+}
+
+func (this *Fossil) Changes() (bool, error) {
+	if lines, err := this.exec("changes"); err != nil {
+		return false, err
+	} else {
+		return len(lines) > 0, nil
+	}
+}
+
+// Remotes returns the autosync remote, if any is configured
+func (this *Fossil) Remotes() ([]string, error) {
+	if lines, err := this.exec("remote-url"); err != nil {
+		return nil, err
+	} else if len(lines) == 0 || lines[0] == "off" {
+		return []string{}, nil
+	} else {
+		return []string{lines[0]}, nil
+	}
+}
+
+func (this *Fossil) Synced() (SyncStateNum, error) {
+	if remotes, err := this.Remotes(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if len(remotes) == 0 {
+		return SYNC_STATE_NO_REMOTE, nil
+	} else if unsent, err := this.unsent(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if unsent > 0 {
+		return SYNC_STATE_AHEAD, nil
+	} else {
+		return SYNC_STATE_SAME, nil
+	}
+}
+
+func (this *Fossil) States() ([]*SyncState, error) {
+	if remotes, err := this.Remotes(); err != nil {
+		return nil, err
+	} else if len(remotes) == 0 {
+		return []*SyncState{{State: SYNC_STATE_NO_REMOTE}}, nil
+	} else {
+		states := make([]*SyncState, 0)
+		for _, remote := range remotes {
+			state := &SyncState{
+				Remote: remote,
+				State:  SYNC_STATE_SAME,
+			}
+			if unsent, err := this.unsent(); err != nil {
+				state.State = SYNC_STATE_FAIL
+				state.Error = err
+			} else {
+				state.Ahead = unsent
+				state.State = aheadBehindState(unsent, 0)
+			}
+			states = append(states, state)
+		}
+		return states, nil
+	}
+}
+
+func (this *Fossil) WithContext(ctx context.Context) Repo {
+	fossil := *this
+	fossil.ctx = ctx
+	return &fossil
+}
+
+func (this *Fossil) Type() string {
+	return "Fossil"
+}
+
+func (this *Fossil) Updates() (bool, error) {
+	return false, nil
+}
+
+// unsent returns amount of local check-ins, which have not been pushed yet
+func (this *Fossil) unsent() (int, error) {
+	if lines, err := this.exec("sql", "SELECT count(*) FROM unsent JOIN event ON event.objid = unsent.rid WHERE event.type = 'ci';"); err != nil {
+		return 0, err
+	} else if len(lines) == 0 {
+		return 0, fmt.Errorf("Failed to count unsent check-ins")
+	} else {
+		return strconv.Atoi(lines[len(lines)-1])
+	}
+}
+
+// exec runs fossil command and returns the lines printed to STDOUT
+func (this *Fossil) exec(args ...string) ([]string, error) {
+	return execLines(this.ctx, "fossil", this.name, this.path, nil, args...)
+}
+
+func init() {
+	addWatch(0, func(entry *Entry, name string) (Repo, error) {
+		for _, checkout := range []string{".fslckout", "_FOSSIL_"} {
+			if stat, err := os.Stat(filepath.Join(entry.Path, checkout)); err != nil {
+				if !os.IsNotExist(err) {
+					return nil, err
+				}
+			} else if stat.IsDir() {
+				return nil, fmt.Errorf("Found \"%s\" in \"%s\", but it is not a file", checkout, entry.Path)
+			} else {
+				return &Fossil{
+					path: entry.Path,
+					name: name,
+				}, nil
+			}
+		}
+		return nil, nil
+	})
+}
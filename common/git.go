@@ -1,33 +1,148 @@
 package common
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	. "github.com/ukautz/repos/common/debug"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Git is a watch of a Git repository
 type (
 	Git struct {
 		name string
+
+		// path is where git runs, which is the work tree or the git dir of
+		// bare repos
 		path string
+
+		// gitDir is the git dir of repos with a separate work tree
+		gitDir string
+
+		// bare is whether the repo has no work tree
+		bare bool
+
+		ctx context.Context
 	}
 
 	gitRemote struct {
-		name     string
-		url      string
+		name string
+
+		// url is fetched from and pushURL pushed to, both after rewriting
+		// by insteadOf and pushInsteadOf
+		url     string
+		pushURL string
+
+		// pushable is whether the push URL is not read-only
 		pushable bool
+
+		// mirror is whether the remote branches are fetched onto the local
+		// branches of a bare repo (eg "git clone --mirror"), so that the
+		// local branches are the remote branches
+		mirror bool
+	}
+
+	gitBranch struct {
+		name string
+		hash string
+
+		// remote and upstream are the remote and the ref of the configured
+		// upstream branch, eg "origin" and "refs/remotes/origin/master"
+		remote   string
+		upstream string
+
+		// tracked is whether ahead and behind are known, which are the amount
+		// of commits the branch is ahead and behind of its upstream branch
+		tracked       bool
+		ahead, behind int
+	}
+)
+
+const (
+	// GIT_BACKEND_EXEC runs git for every operation
+	GIT_BACKEND_EXEC = "exec"
+
+	// GIT_BACKEND_NATIVE reads repositories in-process, see GoGit
+	GIT_BACKEND_NATIVE = "native"
+)
+
+var (
+	// GitBackend selects the implementation of git watches
+	GitBackend = GIT_BACKEND_EXEC
+
+	// gitEnv makes git fail instead of prompting for credentials
+	gitEnv = []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GCM_INTERACTIVE=never",
+	}
+
+	// gitRemotesConfig matches the config keys of remotes and URL rewrites
+	gitRemotesConfig = `^(remote\..*\.(url|pushurl|fetch|mirror)|url\..*\.(insteadof|pushinsteadof))$`
+
+	// gitRemoteTags is the prefix of the refs of fetched remote tags, which
+	// git does not keep per remote by itself
+	gitRemoteTags = "refs/remote-tags/"
+
+	// gitOperations maps files in the git dir to the operation, which is in
+	// progress while they exist, in the order they are checked
+	gitOperations = []struct {
+		file      string
+		operation string
+	}{
+		{"rebase-merge", "rebase"},
+		{"rebase-apply/applying", "am"},
+		{"rebase-apply", "rebase"},
+		{"MERGE_HEAD", "merge"},
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+		{"REVERT_HEAD", "revert"},
+		{"sequencer", "cherry-pick"},
+		{"BISECT_LOG", "bisect"},
+	}
+
+	// gitRemoteFailures contains messages of failed fetches, in the order
+	// they are matched
+	gitRemoteFailures = []struct {
+		reason   RemoteFailure
+		messages []string
+	}{
+		{REMOTE_NOT_FOUND, []string{
+			"repository not found",
+			"does not appear to be a git repository",
+			"returned error: 404",
+		}},
+		{REMOTE_AUTH_FAILED, []string{
+			"authentication failed",
+			"permission denied",
+			"could not read username",
+			"could not read password",
+			"terminal prompts disabled",
+			"host key verification failed",
+			"returned error: 401",
+			"returned error: 403",
+		}},
+		{REMOTE_UNREACHABLE, []string{
+			"could not resolve host",
+			"connection refused",
+			"connection timed out",
+			"operation timed out",
+			"network is unreachable",
+			"no route to host",
+			"failed to connect",
+		}},
 	}
 )
 
 func (this *Git) Changes() (bool, error) {
-	if lines, err := this.exec("status", "--porcelain"); err != nil {
+	if this.bare {
+		return false, nil
+	} else if lines, err := this.exec("status", "--porcelain"); err != nil {
 		return false, err
 	} else if len(lines) == 0 {
 		return false, nil
@@ -36,6 +151,32 @@ func (this *Git) Changes() (bool, error) {
 	}
 }
 
+// ChangeSet returns the changes of the work tree by the porcelain status,
+// which lists untracked directories as a whole
+func (this *Git) ChangeSet() (*ChangeSet, error) {
+	changes := &ChangeSet{}
+	if this.bare {
+		return changes, nil
+	} else if output, err := this.output("status", "--porcelain", "-z"); err != nil {
+		return nil, err
+	} else {
+		entries := strings.Split(output, "\x00")
+		for i := 0; i < len(entries); i++ {
+			entry := entries[i]
+			if len(entry) < 4 {
+				continue
+			} else if x := entry[0]; (x == 'R' || x == 'C') && i+1 < len(entries) {
+				i++
+				changes.add(x, entry[1], entry[3:], entries[i])
+			} else {
+				changes.add(x, entry[1], entry[3:], "")
+			}
+		}
+		changes.sort()
+		return changes, nil
+	}
+}
+
 func (this *Git) Remotes() ([]string, error) {
 	if remotes, err := this.remotes(); err != nil {
 		return nil, err
@@ -49,103 +190,37 @@ func (this *Git) Remotes() ([]string, error) {
 }
 
 func (this *Git) Synced() (SyncStateNum, error) {
-	if branches, err := this.branches(); err != nil {
-		return SYNC_STATE_FAIL, err
-	} else if remotes, err := this.remotes(); err != nil {
+	if states, err := this.States(); err != nil {
 		return SYNC_STATE_FAIL, err
 	} else {
-		for _, remote := range remotes {
-			if err := this.fetch(remote.name); err != nil {
-				return SYNC_STATE_FAIL, err
-			} else if remoteBranches, err := this.remoteBranches(remote.name); err != nil {
-				return SYNC_STATE_FAIL, err
-			} else {
-				for _, branch := range branches {
-					if _, ok := remoteBranches[branch]; !ok {
-						continue
-					}
-					if lines, err := this.diff(remote.name, branch); err != nil {
-						return SYNC_STATE_FAIL, err
-					} else if len(lines) > 0 {
-						if ahead, err := this.isAhead(remote.name, branch); err != nil {
-							return SYNC_STATE_FAIL, err
-						} else if ahead {
-							return SYNC_STATE_AHEAD, nil
-						} else {
-							return SYNC_STATE_BEHIND, nil
-						}
-					}
-				}
-			}
-		}
-		return SYNC_STATE_SAME, nil
+		return SyncedStates(states)
 	}
 }
 
 func (this *Git) States() ([]*SyncState, error) {
-	if branches, err := this.branches(); err != nil {
-		return nil, err
-	} else if remotes, err := this.remotes(); err != nil {
+	if remotes, err := this.remotes(); err != nil {
 		return nil, err
+	} else if len(remotes) == 0 {
+		state, err := this.noRemoteState()
+		return []*SyncState{{State: state, Error: err}}, nil
 	} else {
-		states := make([]*SyncState, 0)
-		for _, remote := range remotes {
-			if err := this.fetch(remote.name); err != nil {
-				return nil, err
-			} else if remoteBranches, err := this.remoteBranches(remote.name); err != nil {
-				return nil, err
-			} else {
-				for _, branch := range branches {
-					if _, ok := remoteBranches[branch]; !ok {
-						states = append(states, &SyncState{
-							Remote: remote.name,
-							Branch: branch,
-							State:  SYNC_STATE_MISSING,
-						})
-					} else if lines, err := this.diff(remote.name, branch); err != nil {
-						states = append(states, &SyncState{
-							Remote: remote.name,
-							Branch: branch,
-							State:  SYNC_STATE_FAIL,
-							Error:  err,
-						})
-					} else if len(lines) > 0 {
-						if !remote.pushable {
-
-						} else if behind, err := this.isAhead(remote.name, branch); err != nil {
-							states = append(states, &SyncState{
-								Remote: remote.name,
-								Branch: branch,
-								State:  SYNC_STATE_FAIL,
-								Error:  err,
-							})
-						} else if behind {
-							states = append(states, &SyncState{
-								Remote: remote.name,
-								Branch: branch,
-								State:  SYNC_STATE_BEHIND,
-							})
-						} else {
-							states = append(states, &SyncState{
-								Remote: remote.name,
-								Branch: branch,
-								State:  SYNC_STATE_AHEAD,
-							})
-						}
-					} else {
-						states = append(states, &SyncState{
-							Remote: remote.name,
-							Branch: branch,
-							State:  SYNC_STATE_SAME,
-						})
-					}
-				}
-			}
+		if fetched, err := this.fetchAll(remotes); err != nil {
+			return nil, err
+		} else if branches, tags, refs, err := this.refSnapshot(); err != nil {
+			return nil, err
+		} else if err := this.deleteRefs(staleRemoteTags(remotes, refs)); err != nil {
+			return nil, err
+		} else {
+			states := branchStates(branches, remotes, refs, fetched, this.aheadBehind)
+			return append(states, tagStates(tags, remotes, refs, fetched)...), nil
 		}
-		return states, nil
 	}
 }
 
+func (this *Git) WithContext(ctx context.Context) Repo {
+	return this.withContext(ctx)
+}
+
 func (this *Git) Type() string {
 	return "Git"
 }
@@ -154,140 +229,946 @@ func (this *Git) Updates() (bool, error) {
 	return false, nil
 }
 
-// fetch fetches remote
-func (this *Git) fetch(remote string) error {
-	_, err := this.exec("fetch", remote)
-	return err
+// Annex returns state of git-annex content or nil, if the repo is not annexed
+func (this *Git) Annex() (*AnnexState, error) {
+	lacking := []string{}
+	if lines, err := this.exec("config", "--get", "annex.uuid"); err != nil || len(lines) == 0 {
+		return nil, nil
+	} else if !this.bare {
+		if lacking, err = this.exec("annex", "find", "--in=here", "--lackingcopies=1"); err != nil {
+			return nil, err
+		}
+	}
+	if unsynced, err := this.exec("rev-list", "--count", "refs/heads/git-annex", "--not", "--remotes=*/git-annex", "--remotes=*/synced/git-annex"); err != nil {
+		return nil, err
+	} else {
+		return &AnnexState{
+			Lacking:  lacking,
+			Unsynced: len(unsynced) > 0 && unsynced[0] != "0",
+		}, nil
+	}
 }
 
-// branches returns list of local branches
-func (this *Git) branches() ([]string, error) {
-	if lines, err := this.exec("branch", "--no-color"); err != nil {
+// Worktrees returns paths of all linked worktrees, except the own one
+func (this *Git) Worktrees() ([]string, error) {
+	if lines, err := this.exec("worktree", "list", "--porcelain"); err != nil {
 		return nil, err
 	} else {
-		branches := make([]string, len(lines))
-		rx := regexp.MustCompile(`^\*?\s+`)
-		for i, line := range lines {
-			branches[i] = rx.ReplaceAllString(line, "")
+		own, _ := filepath.EvalSymlinks(this.path)
+		worktrees := []string{}
+		for _, line := range lines {
+			if strings.Index(line, "worktree ") != 0 {
+				continue
+			}
+			// skip own and stale worktrees, which do not exist anymore
+			path := line[len("worktree "):]
+			if real, err := filepath.EvalSymlinks(path); err != nil || real == own {
+				continue
+			}
+			worktrees = append(worktrees, path)
 		}
-		return branches, nil
+		return worktrees, nil
 	}
 }
 
-// diff returns list of files which have changed between local and remote
-func (this *Git) diff(remote, branch string) ([]string, error) {
-	return this.exec("diff", "--name-only", fmt.Sprintf("%s/%s", remote, branch))
+// Submodules returns state of all initialized submodules, including nested
+func (this *Git) Submodules() ([]*Submodule, error) {
+	return this.submodules("")
 }
 
-// isAhead returns whether REMOTE branch is ahead of LOCAL branch
-func (this *Git) isAhead(remote, branch string) (bool, error) {
-	if lines, err := this.exec("push", "--dry-run", remote, branch); lines == nil || len(lines) == 0 {
-		if err != nil {
-			return false, err
+// Unbacked returns amount of commits reachable from local branches or tags,
+// but not from any remote branch, and the local branches containing them.
+// Mirrors have nothing unbacked, since their local branches are remote.
+func (this *Git) Unbacked() (int, []string, error) {
+	if mirrored, err := this.mirrored(); err != nil {
+		return 0, nil, err
+	} else if mirrored {
+		return 0, []string{}, nil
+	} else if commits, err := this.exec("rev-list", "--branches", "--tags", "--not", "--remotes", "--glob="+gitRemoteTags+"*"); err != nil {
+		return 0, nil, err
+	} else if len(commits) == 0 {
+		return 0, []string{}, nil
+	} else if branches, _, _, err := this.refSnapshot(); err != nil {
+		return 0, nil, err
+	} else {
+		return len(commits), unbackedBranches(branches, commits), nil
+	}
+}
+
+// Stashes returns all entries of the stash, latest first
+func (this *Git) Stashes() ([]*Stash, error) {
+	if this.bare {
+		return []*Stash{}, nil
+	} else if lines, err := this.exec("stash", "list", "--format=%gd%x09%ct%x09%gs"); err != nil {
+		return nil, err
+	} else {
+		stashes := make([]*Stash, 0)
+		for _, line := range lines {
+			p := strings.SplitN(line, "\t", 3)
+			if len(p) != 3 {
+				continue
+			} else if ts, err := strconv.ParseInt(p[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("Failed to parse time of %s: %s", p[0], err)
+			} else {
+				stashes = append(stashes, &Stash{
+					Name:    p[0],
+					Message: p[2],
+					Time:    time.Unix(ts, 0),
+				})
+			}
+		}
+		return stashes, nil
+	}
+}
+
+// Head returns the operation in progress and whether HEAD is detached with
+// commits, which are only reachable from HEAD
+func (this *Git) Head() (*HeadState, error) {
+	head := &HeadState{}
+	args := []string{"rev-parse"}
+	for _, op := range gitOperations {
+		args = append(args, "--git-path", op.file)
+	}
+	if files, err := this.exec(args...); err != nil {
+		return nil, err
+	} else if len(files) != len(gitOperations) {
+		return nil, fmt.Errorf("Failed to resolve paths in git dir")
+	} else {
+		for i, file := range files {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(this.path, file)
+			}
+			if _, err := os.Stat(file); err == nil {
+				head.Operation = gitOperations[i].operation
+				break
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	if _, err := this.exec("symbolic-ref", "--quiet", "HEAD"); err == nil {
+		return head, nil
+	} else if exitCode(err) != 1 {
+		return nil, err
+	}
+	head.Detached = true
+	if count, err := this.revCount("HEAD", "--not", "--branches", "--remotes", "--tags"); err != nil {
+		return nil, err
+	} else {
+		head.Unreachable = count
+		return head, nil
+	}
+}
+
+func (this *Git) LastFetch() (time.Time, error) {
+	if lines, err := this.exec("rev-parse", "--git-path", "FETCH_HEAD"); err != nil {
+		return time.Time{}, err
+	} else if len(lines) == 0 {
+		return time.Time{}, fmt.Errorf("Failed to find FETCH_HEAD")
+	} else {
+		file := lines[0]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(this.path, file)
+		}
+		if stat, err := os.Stat(file); err != nil {
+			if os.IsNotExist(err) {
+				return time.Time{}, nil
+			}
+			return time.Time{}, err
 		} else {
-			return false, fmt.Errorf("Oopsie.. something went very wrong")
+			return stat.ModTime(), nil
 		}
+	}
+}
+
+// fetchAll fetches all remotes, including their tags into "refs/remote-tags"
+// for all but mirrors, unless fetching is disabled or the last fetch is within
+// the FetchTTL. Branches and tags deleted on the remote are pruned, so that
+// they are no longer considered published. The fetched remotes are returned
+// with nil or, if they are not accessible, with their RemoteError.
+func (this *Git) fetchAll(remotes []*gitRemote) (map[string]error, error) {
+	if due, err := fetchDue(this.name, this.LastFetch); err != nil {
+		return nil, err
+	} else if !due {
+		return make(map[string]error), nil
 	} else {
-		for _, line := range lines {
-			if strings.Index(line, "[rejected]") != -1 {
-				return true, nil
-			} else if strings.Index(line, "the tip of your current branch is behind") != -1 {
-				return true, nil
+		return this.fetchRemotes(remotes, this.sshCommand())
+	}
+}
+
+// fetchDue returns whether remotes are fetched, which they are not if fetching
+// is disabled or the last fetch is within the FetchTTL
+func fetchDue(name string, lastFetch func() (time.Time, error)) (bool, error) {
+	if NoFetch {
+		return false, nil
+	} else if FetchTTL > 0 {
+		if last, err := lastFetch(); err != nil {
+			return false, err
+		} else if age := time.Since(last); age < FetchTTL {
+			Debug(DEBUG2, "Skip fetching %s, last fetch %s ago", name, age)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// fetchRemotes fetches the remotes with the ssh command, if not empty
+func (this *Git) fetchRemotes(remotes []*gitRemote, sshCommand string) (map[string]error, error) {
+	fetched := make(map[string]error)
+	env := []string{}
+	if sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	for _, remote := range remotes {
+		if err := withHost(this.ctx, remote.url, func() error {
+			args := []string{"fetch", "--prune", remote.name}
+			if !remote.mirror {
+				tags := "remote." + remote.name + ".fetch=+refs/tags/*:" + gitRemoteTags + remote.name + "/*"
+				args = append([]string{"-c", tags}, args...)
 			}
+			_, err := this.execEnv(env, args...)
+			return err
+		}); err == nil {
+			fetched[remote.name] = nil
+		} else if reason := gitRemoteFailure(stderr(err)); reason != 0 {
+			fetched[remote.name] = &RemoteError{
+				Remote: remote.name,
+				Reason: reason,
+				Err:    err,
+			}
+		} else {
+			return nil, err
 		}
+	}
+	return fetched, nil
+}
+
+// mirrored checks whether the repo is a bare mirror of any remote
+func (this *Git) mirrored() (bool, error) {
+	if !this.bare {
 		return false, nil
+	} else if remotes, err := this.remotes(); err != nil {
+		return false, err
+	} else {
+		return hasMirror(remotes), nil
+	}
+}
+
+// hasMirror returns whether any of the remotes is mirrored
+func hasMirror(remotes []*gitRemote) bool {
+	for _, remote := range remotes {
+		if remote.mirror {
+			return true
+		}
 	}
+	return false
 }
 
-// remotes returns list of remote repos
+// sshCommand returns the ssh command in batch mode, so that it fails instead
+// of asking for passwords or confirmation of host keys. Nothing is returned,
+// if a GIT_SSH program is used.
+func (this *Git) sshCommand() string {
+	return gitSSHCommand(func() string {
+		if lines, err := this.exec("config", "core.sshCommand"); err == nil && len(lines) > 0 {
+			return lines[0]
+		}
+		return ""
+	})
+}
+
+// gitSSHCommand returns the ssh command of the environment or otherwise the
+// configured one in batch mode, or empty if git runs GIT_SSH
+func gitSSHCommand(configured func() string) string {
+	command := os.Getenv("GIT_SSH_COMMAND")
+	if command == "" && os.Getenv("GIT_SSH") != "" {
+		return ""
+	} else if command == "" {
+		if command = configured(); command == "" {
+			command = "ssh"
+		}
+	}
+	return command + " -o BatchMode=yes"
+}
+
+// gitRemoteFailure classifies the output to STDERR of a failed fetch, if the
+// remote is not accessible
+func gitRemoteFailure(output string) RemoteFailure {
+	output = strings.ToLower(output)
+	for _, failure := range gitRemoteFailures {
+		for _, message := range failure.messages {
+			if strings.Contains(output, message) {
+				return failure.reason
+			}
+		}
+	}
+	return 0
+}
+
+// aheadBehind returns the amount of commits only reachable from local and
+// the amount only reachable from remote
+func (this *Git) aheadBehind(local, remote string) (int, int, error) {
+	if lines, err := this.exec("rev-list", "--left-right", "--count", local+"..."+remote, "--"); err != nil {
+		return 0, 0, err
+	} else if len(lines) == 0 {
+		return 0, 0, fmt.Errorf("Failed to count commits of %s and %s", local, remote)
+	} else if p := strings.Fields(lines[len(lines)-1]); len(p) != 2 {
+		return 0, 0, fmt.Errorf("Failed to count commits of %s and %s: %s", local, remote, lines[len(lines)-1])
+	} else if ahead, err := strconv.Atoi(p[0]); err != nil {
+		return 0, 0, err
+	} else if behind, err := strconv.Atoi(p[1]); err != nil {
+		return 0, 0, err
+	} else {
+		return ahead, behind, nil
+	}
+}
+
+// remotes returns list of remote repos with their fetch and push URLs. Only
+// bare repos can mirror their remotes.
 func (this *Git) remotes() ([]*gitRemote, error) {
-	if lines, err := this.exec("remote", "-v"); err != nil {
+	if lines, err := this.config(gitRemotesConfig); err != nil {
 		return nil, err
 	} else {
-		rxSplit := regexp.MustCompile(`\s+`)
-		rxPush := regexp.MustCompile(`git@`)
-		rxGithub := regexp.MustCompile(`github.com`)
-		remotes := make([]*gitRemote, 0)
-		seen := make(map[string]bool)
-		for _, line := range lines {
-			p := rxSplit.Split(line, 3)
-			if len(p) == 3 && !seen[p[0]] {
-				seen[p[0]] = true
-				pushable := true
-				if rxGithub.MatchString(p[1]) {
-					pushable = rxPush.MatchString(p[1])
-				}
-				remotes = append(remotes, &gitRemote{
-					name:     p[0],
-					url:      p[1],
-					pushable: pushable,
-				})
-			}
+		remotes := newGitRemotes(this.path, lines)
+		for _, remote := range remotes {
+			remote.mirror = remote.mirror && this.bare
 		}
 		return remotes, nil
 	}
 }
 
-// remotes returns list of remote repos
-func (this *Git) remoteBranches(remote string) (map[string]bool, error) {
-	if lines, err := this.exec("branch", "-a"); err != nil {
-		return nil, err
+// config returns the "key value" lines of all config keys matching the
+// regex, which is empty if there are none. Further args are passed before the
+// regex, eg "--file", ".gitmodules".
+func (this *Git) config(rx string, args ...string) ([]string, error) {
+	if lines, err := this.exec(append(append([]string{"config"}, args...), "--get-regexp", rx)...); err != nil && exitCode(err) == 1 {
+		return []string{}, nil
+	} else {
+		return lines, err
+	}
+}
+
+// newGitRemotes creates remotes from the "key value" lines of their url,
+// pushurl, fetch and mirror config and the insteadOf and pushInsteadOf config,
+// which rewrite the URLs as git does: Push URLs are rewritten by pushInsteadOf
+// only, if there is no pushurl.
+func newGitRemotes(path string, lines []string) []*gitRemote {
+	names := make([]string, 0)
+	urls := make(map[string]string)
+	pushURLs := make(map[string]string)
+	mirrors := make(map[string]bool)
+	insteadOf := make(map[string]string)
+	pushInsteadOf := make(map[string]string)
+	for _, line := range lines {
+		p := strings.SplitN(line, " ", 2)
+		i := strings.LastIndex(p[0], ".")
+		if i < 0 {
+			continue
+		}
+		section, key, value := p[0][:i], p[0][i+1:], ""
+		if len(p) == 2 {
+			value = p[1]
+		} else if key != "mirror" {
+			continue
+		}
+		if strings.Index(section, "remote.") == 0 {
+			name := section[len("remote."):]
+			if key == "url" && urls[name] == "" {
+				names = append(names, name)
+				urls[name] = value
+			} else if key == "pushurl" && pushURLs[name] == "" {
+				pushURLs[name] = value
+			} else if key == "fetch" && gitMirrorRefspec(value) {
+				mirrors[name] = true
+			} else if key == "mirror" && gitBool(value) {
+				mirrors[name] = true
+			}
+		} else if strings.Index(section, "url.") == 0 {
+			if key == "insteadof" {
+				insteadOf[value] = section[len("url."):]
+			} else if key == "pushinsteadof" {
+				pushInsteadOf[value] = section[len("url."):]
+			}
+		}
+	}
+	remotes := make([]*gitRemote, len(names))
+	for i, name := range names {
+		url, _ := rewriteGitURL(urls[name], insteadOf)
+		pushURL := url
+		if push := pushURLs[name]; push != "" {
+			pushURL, _ = rewriteGitURL(push, insteadOf)
+		} else if rewritten, ok := rewriteGitURL(urls[name], pushInsteadOf); ok {
+			pushURL = rewritten
+		}
+		remotes[i] = &gitRemote{
+			name:     name,
+			url:      url,
+			pushURL:  pushURL,
+			pushable: !readOnlyRemote(pushURL) && !gitPushDisabled(path, pushURL),
+			mirror:   mirrors[name],
+		}
+	}
+	return remotes
+}
+
+// gitMirrorRefspec checks whether a fetch refspec maps the remote branches
+// onto the local branches, eg "+refs/*:refs/*"
+func gitMirrorRefspec(refspec string) bool {
+	p := strings.SplitN(strings.TrimPrefix(refspec, "+"), ":", 2)
+	return len(p) == 2 && p[0] == p[1] && (p[0] == "refs/*" || p[0] == "refs/heads/*")
+}
+
+// gitBool checks whether a config value is true. Keys without value are true.
+func gitBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+// rewriteGitURL replaces the longest prefix of the URL, which matches any of
+// the (prefix => replacement) rules, and returns whether it did
+func rewriteGitURL(url string, rules map[string]string) (string, bool) {
+	longest := ""
+	for prefix := range rules {
+		if strings.Index(url, prefix) == 0 && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return url, false
+	}
+	return rules[longest] + url[len(longest):], true
+}
+
+// gitPushDisabled checks whether the push URL is a placeholder, which is
+// neither a URL nor an existing path, eg "no_push" as set to prevent pushes
+// to upstream remotes
+func gitPushDisabled(path, pushURL string) bool {
+	if strings.Contains(pushURL, "://") || remoteHost(pushURL) != "" {
+		return false
+	} else if !filepath.IsAbs(pushURL) {
+		pushURL = filepath.Join(path, pushURL)
+	}
+	_, err := os.Stat(pushURL)
+	return err != nil
+}
+
+// refSnapshot returns the local branches, with their upstream branches and
+// the commit counts relative to them, (name => object) map of local tags and
+// (name => object) map of all branch and remote tag refs, eg
+// "refs/remotes/origin/master", read with a single git call
+func (this *Git) refSnapshot() ([]*gitBranch, map[string]string, map[string]string, error) {
+	if lines, err := this.exec("for-each-ref", "--format=%(refname)%09%(objectname)%09%(upstream:remotename)%09%(upstream)%09%(upstream:track,nobracket)", "refs/heads", "refs/remotes", "refs/tags", gitRemoteTags); err != nil {
+		return nil, nil, nil, err
 	} else {
-		rx := regexp.MustCompile(`^\s*remotes/(.+)$`)
-		branches := map[string]bool{}
-		prefix := remote + "/"
+		branches := make([]*gitBranch, 0)
+		tags := make(map[string]string)
+		refs := make(map[string]string)
 		for _, line := range lines {
-			if rx.MatchString(line) {
-				p := rx.FindStringSubmatch(line)
-				if strings.Index(p[1], prefix) == 0 {
-					branches[p[1][len(prefix):]] = true
+			p := strings.Split(line, "\t")
+			if len(p) != 5 {
+				continue
+			} else if strings.Index(p[0], "refs/tags/") == 0 {
+				tags[p[0][len("refs/tags/"):]] = p[1]
+				continue
+			}
+			refs[p[0]] = p[1]
+			if strings.Index(p[0], "refs/heads/") != 0 {
+				continue
+			} else if branch := newGitBranch(strings.TrimPrefix(p[0], "refs/heads/"), p[1], p[2], p[3]); branch.upstream == "" {
+				branches = append(branches, branch)
+			} else if branch.ahead, branch.behind, err = parseGitTrack(p[4]); err != nil {
+				return nil, nil, nil, fmt.Errorf("Failed to parse upstream of branch %s: %s", branch.name, err)
+			} else {
+				branch.tracked = p[4] != "gone"
+				branches = append(branches, branch)
+			}
+		}
+		return branches, tags, refs, nil
+	}
+}
+
+// parseGitTrack parses the commit counts of "%(upstream:track,nobracket)",
+// eg "ahead 1, behind 2". Gone upstream branches have no counts.
+func parseGitTrack(track string) (ahead int, behind int, err error) {
+	if track == "" || track == "gone" {
+		return 0, 0, nil
+	}
+	for _, part := range strings.Split(track, ", ") {
+		if p := strings.Fields(part); len(p) != 2 {
+			return 0, 0, fmt.Errorf("Unexpected tracking info \"%s\"", track)
+		} else if p[0] == "ahead" {
+			ahead, err = strconv.Atoi(p[1])
+		} else if p[0] == "behind" {
+			behind, err = strconv.Atoi(p[1])
+		} else {
+			err = fmt.Errorf("Unexpected tracking info \"%s\"", track)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return ahead, behind, nil
+}
+
+// newGitBranch creates branch. Branches tracking another local branch are
+// considered to have no upstream.
+func newGitBranch(name, hash, remote, upstream string) *gitBranch {
+	if remote == "." || upstream == "" {
+		remote = ""
+		upstream = ""
+	}
+	return &gitBranch{
+		name:     name,
+		hash:     hash,
+		remote:   remote,
+		upstream: upstream,
+	}
+}
+
+// aheadBehind returns the amount of commits the branch is ahead and behind of
+// the remote branch. The commits are only counted, if the counts are not known
+// from the upstream tracking info and the commits differ.
+func (this *gitBranch) aheadBehind(upstream, hash string, count func(local, remote string) (int, int, error)) (int, int, error) {
+	if this.tracked && upstream == this.upstream {
+		return this.ahead, this.behind, nil
+	} else if hash == this.hash {
+		return 0, 0, nil
+	} else {
+		return count("refs/heads/"+this.name, upstream)
+	}
+}
+
+// branchStates compares local branches with their upstream branches. Branches
+// without upstream are compared with the equally named branches of all remotes
+// and are untracked, if there are none. The local branches of mirrors are the
+// branches of the mirrored remote.
+func branchStates(branches []*gitBranch, remotes []*gitRemote, refs map[string]string, failed map[string]error, aheadBehind func(local, remote string) (int, int, error)) []*SyncState {
+	states := make([]*SyncState, 0)
+	add := func(branch *gitBranch, remote *gitRemote, upstream string) {
+		state := &SyncState{
+			Remote: remote.name,
+			Branch: branch.name,
+		}
+		prefix := "refs/remotes/" + remote.name + "/"
+		if remote.mirror {
+			prefix = "refs/heads/"
+		}
+		if name := strings.TrimPrefix(upstream, prefix); name != branch.name {
+			state.Upstream = name
+		}
+		var err error
+		if err = failed[remote.name]; err != nil {
+			state.State = SYNC_STATE_UNVERIFIED
+			state.Error = err
+		} else if hash, ok := refs[upstream]; !ok {
+			state.State = SYNC_STATE_MISSING
+		} else if state.Ahead, state.Behind, err = branch.aheadBehind(upstream, hash, aheadBehind); err != nil {
+			state.State = SYNC_STATE_FAIL
+			state.Error = err
+		} else if state.State = aheadBehindState(state.Ahead, state.Behind); state.Ahead > 0 && !remote.pushable {
+			state.State = SYNC_STATE_AHEAD_READ_ONLY
+		}
+		states = append(states, state)
+	}
+	for _, branch := range branches {
+		if branch.upstream != "" {
+			remote := &gitRemote{name: branch.remote, pushable: true}
+			for _, r := range remotes {
+				if r.name == branch.remote {
+					remote = r
+				}
+			}
+			add(branch, remote, branch.upstream)
+			continue
+		}
+		found := false
+		for _, remote := range remotes {
+			if remote.mirror {
+				found = true
+				add(branch, remote, "refs/heads/"+branch.name)
+			} else if upstream := "refs/remotes/" + remote.name + "/" + branch.name; refs[upstream] != "" {
+				found = true
+				add(branch, remote, upstream)
+			}
+		}
+		if !found {
+			states = append(states, &SyncState{
+				Branch: branch.name,
+				State:  SYNC_STATE_UNTRACKED,
+			})
+		}
+	}
+
+	// failed remotes are reported, even if no branch is compared with them
+	for _, remote := range remotes {
+		if err := failed[remote.name]; err != nil {
+			states = append(states, &SyncState{
+				Remote: remote.name,
+				State:  SYNC_STATE_UNVERIFIED,
+				Error:  err,
+			})
+		}
+	}
+	return states
+}
+
+// tagStates compares local tags with the tags of all pushable remotes, which
+// have been fetched now or before. Remotes without any fetched tags are only
+// compared, if they have been fetched now, since they are not known otherwise.
+// Tags, which any known remote has with the same object, are published (eg
+// the tags of the upstream project in a fork), as are all tags of mirrors.
+func tagStates(tags map[string]string, remotes []*gitRemote, refs map[string]string, fetched map[string]error) []*SyncState {
+	known := make([]*gitRemote, 0)
+	published := make(map[string]bool)
+	for _, remote := range remotes {
+		prefix := gitRemoteTags + remote.name + "/"
+		if err, ok := fetched[remote.name]; err != nil {
+			continue
+		} else if remote.mirror {
+			return []*SyncState{}
+		} else if !ok {
+			found := false
+			for ref := range refs {
+				if strings.Index(ref, prefix) == 0 {
+					found = true
+					break
 				}
 			}
+			if !found {
+				continue
+			}
+		}
+		known = append(known, remote)
+		for name, hash := range tags {
+			if refs[prefix+name] == hash {
+				published[name] = true
+			}
+		}
+	}
+	names := make([]string, 0)
+	for name := range tags {
+		if !published[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	states := make([]*SyncState, 0)
+	for _, remote := range known {
+		if !remote.pushable {
+			continue
+		}
+		prefix := gitRemoteTags + remote.name + "/"
+		for _, name := range names {
+			if hash, ok := refs[prefix+name]; !ok {
+				states = append(states, &SyncState{Remote: remote.name, Tag: name, State: SYNC_STATE_TAG_MISSING})
+			} else if hash != tags[name] {
+				states = append(states, &SyncState{Remote: remote.name, Tag: name, State: SYNC_STATE_TAG_DIFFERENT})
+			}
 		}
-		return branches, nil
 	}
+	return states
 }
 
-// remotes returns list of remote repos
-func (this *Git) exec(args ...string) ([]string, error) {
-	Debug(DEBUG2, "Git exec [%s: %s]: %s", this.name, this.path, strings.Join(args, " "))
-	cmd := exec.Command("git", args...)
-	cmd.Dir = this.path
-	errOut := bytes.NewBuffer(nil)
-	stdOut := bytes.NewBuffer(nil)
-	cmd.Stderr = errOut
-	cmd.Stdout = stdOut
-	cmd.Stdin = os.Stdin
-	err := cmd.Run()
-	lines := []string{}
-	for n, buf := range map[string]*bytes.Buffer{"err": errOut, "out": stdOut} {
-		scn := bufio.NewScanner(buf)
-		for scn.Scan() {
-			if line := scn.Text(); line != "" {
-				lines = append(lines, line)
-				Debug(DEBUG3, " %s: %s", n, line)
+// staleRemoteTags returns the refs in "refs/remote-tags" of remotes, which do
+// not exist anymore (eg removed or renamed). Mirrors have none, since all their
+// refs are fetched from the remote.
+func staleRemoteTags(remotes []*gitRemote, refs map[string]string) []string {
+	stale := make([]string, 0)
+	if hasMirror(remotes) {
+		return stale
+	}
+	for ref := range refs {
+		if strings.Index(ref, gitRemoteTags) != 0 {
+			continue
+		}
+		known := false
+		for _, remote := range remotes {
+			if strings.Index(ref, gitRemoteTags+remote.name+"/") == 0 {
+				known = true
+				break
 			}
 		}
+		if !known {
+			stale = append(stale, ref)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// unbackedBranches returns the names of the branches containing any of the
+// unbacked commits. Those are the branches with an unbacked tip, since all
+// ancestors of a backed commit are backed.
+func unbackedBranches(branches []*gitBranch, commits []string) []string {
+	unbacked := make(map[string]bool)
+	for _, commit := range commits {
+		unbacked[commit] = true
+	}
+	names := []string{}
+	for _, branch := range branches {
+		if unbacked[branch.hash] {
+			names = append(names, branch.name)
+		}
+	}
+	return names
+}
+
+// submodules returns state of the initialized submodules referenced in HEAD
+// and recurses into them
+func (this *Git) submodules(prefix string) ([]*Submodule, error) {
+	if _, err := os.Stat(filepath.Join(this.path, ".gitmodules")); err != nil {
+		if os.IsNotExist(err) {
+			return []*Submodule{}, nil
+		}
+		return nil, err
+	}
+	lines, err := this.config(`^submodule\..*\.path$`, "--file", ".gitmodules")
+	if err != nil {
+		return nil, err
+	} else if len(lines) == 0 {
+		return []*Submodule{}, nil
+	}
+
+	// without any commit (unborn HEAD) there are no submodules referenced
+	if _, err := this.exec("rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		if exitCode(err) == 1 {
+			return []*Submodule{}, nil
+		}
+		return nil, err
+	}
+	args := []string{"ls-tree", "HEAD", "--"}
+	for _, line := range lines {
+		if p := strings.SplitN(line, " ", 2); len(p) == 2 {
+			args = append(args, p[1])
+		}
+	}
+	if lines, err = this.exec(args...); err != nil {
+		return nil, err
+	}
+	rx := regexp.MustCompile(`^160000 commit ([0-9a-f]+)\t(.+)$`)
+	submodules := make([]*Submodule, 0)
+	for _, line := range lines {
+		p := rx.FindStringSubmatch(line)
+		if p == nil {
+			continue
+		}
+		path := filepath.Join(this.path, p[2])
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			Debug(DEBUG3, "Skipping uninitialized submodule %s of %s", p[2], this.name)
+			continue
+		}
+		sub := &Git{
+			name: this.name,
+			path: path,
+			ctx:  this.ctx,
+		}
+		submodule := &Submodule{
+			Path:   prefix + p[2],
+			Commit: p[1],
+		}
+		submodules = append(submodules, submodule)
+		if submodule.Dirty, err = sub.Changes(); err != nil {
+			submodule.Error = err
+		} else if submodule.Unpushed, err = sub.unpushed(submodule.Commit); err != nil {
+			submodule.Error = err
+		} else if nested, err := sub.submodules(submodule.Path + "/"); err != nil {
+			submodule.Error = err
+		} else {
+			submodules = append(submodules, nested...)
+		}
+	}
+	return submodules, nil
+}
+
+// unpushed returns whether commit is not contained in any remote branch. The
+// remotes are only fetched, if the commit is not known to be pushed.
+func (this *Git) unpushed(commit string) (bool, error) {
+	contained := func() (bool, error) {
+		if lines, err := this.exec("for-each-ref", "--format=%(refname)", "--contains", commit, "refs/remotes"); err != nil {
+			return false, err
+		} else {
+			return len(lines) > 0, nil
+		}
+	}
+	if ok, err := contained(); err != nil || ok {
+		return false, err
+	} else if remotes, err := this.remotes(); err != nil {
+		return false, err
+	} else if _, err := this.fetchAll(remotes); err != nil {
+		return false, err
+	} else {
+		ok, err := contained()
+		return !ok, err
+	}
+}
+
+// noRemoteState returns the state of a repo without remotes, which is only
+// in sync, if there is nothing to back up
+func (this *Git) noRemoteState() (SyncStateNum, error) {
+	if lines, err := this.exec("rev-list", "--max-count=1", "--all"); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if len(lines) > 0 {
+		return SYNC_STATE_NO_REMOTE, nil
+	} else {
+		return SYNC_STATE_SAME, nil
+	}
+}
+
+// deleteRefs deletes the given refs
+func (this *Git) deleteRefs(refs []string) error {
+	for _, ref := range refs {
+		if _, err := this.exec("update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revCount returns amount of commits of a rev-list query
+func (this *Git) revCount(args ...string) (int, error) {
+	if lines, err := this.exec(append([]string{"rev-list", "--count"}, args...)...); err != nil {
+		return 0, err
+	} else if len(lines) == 0 {
+		return 0, fmt.Errorf("Failed to count commits of %s", strings.Join(args, " "))
+	} else {
+		return strconv.Atoi(lines[len(lines)-1])
+	}
+}
+
+// exec runs git command and returns the lines printed to STDOUT
+func (this *Git) exec(args ...string) ([]string, error) {
+	return this.execEnv(nil, args...)
+}
+
+// execEnv runs git command non-interactively with additional environment
+// variables and returns the lines printed to STDOUT
+func (this *Git) execEnv(env []string, args ...string) ([]string, error) {
+	label, args := this.command(args)
+	return execLabeled(this.ctx, label, "git", this.name, this.path, append(append([]string{}, gitEnv...), env...), args...)
+}
+
+// output runs git command non-interactively and returns the whole output to
+// STDOUT
+func (this *Git) output(args ...string) (string, error) {
+	label, args := this.command(args)
+	return execOutput(this.ctx, label, "git", this.name, this.path, gitEnv, args...)
+}
+
+// command returns the label of a git command, which is named by its first
+// argument after the config options, and its arguments for the repo
+func (this *Git) command(args []string) (string, []string) {
+	label := "git"
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+		} else {
+			label += " " + args[i]
+			break
+		}
+	}
+	if this.gitDir != "" {
+		args = append([]string{"--git-dir", this.gitDir, "--work-tree", this.path}, args...)
+	}
+	return label, args
+}
+
+// withContext returns a copy of the repo bound to the context
+func (this *Git) withContext(ctx context.Context) *Git {
+	git := *this
+	git.ctx = ctx
+	return &git
+}
+
+// gitDirFile resolves the git dir from a ".git" file, as used by linked
+// worktrees and submodules
+func gitDirFile(path, file string) (string, error) {
+	if raw, err := ioutil.ReadFile(file); err != nil {
+		return "", err
+	} else if line := strings.TrimSpace(string(raw)); strings.Index(line, "gitdir:") != 0 {
+		return "", fmt.Errorf("Found \".git\" in \"%s\", but it is neither a directory nor a gitdir file", path)
+	} else {
+		dir := strings.TrimSpace(line[len("gitdir:"):])
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(path, dir)
+		}
+		if stat, err := os.Stat(dir); err != nil {
+			return "", fmt.Errorf("Found \".git\" in \"%s\", but gitdir \"%s\" is missing: %s", path, dir, err)
+		} else if !stat.IsDir() {
+			return "", fmt.Errorf("Found \".git\" in \"%s\", but gitdir \"%s\" is not a directory", path, dir)
+		} else {
+			return dir, nil
+		}
+	}
+}
+
+// newGitRepo returns the watch of the selected git backend
+func newGitRepo(g *Git) (Repo, error) {
+	if GitBackend == GIT_BACKEND_NATIVE {
+		return newGoGit(g)
+	}
+	return g, nil
+}
+
+// isGitDir checks whether path is a git dir itself (eg bare repos)
+func isGitDir(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// isBareGitDir checks whether path is the git dir of a bare repo and not the
+// ".git" dir of a work tree
+func isBareGitDir(path, name string) (bool, error) {
+	if !isGitDir(path) || filepath.Base(path) == ".git" {
+		return false, nil
+	} else if lines, err := execLines(nil, "git", name, path, gitEnv, "rev-parse", "--is-bare-repository"); err != nil {
+		return false, err
+	} else {
+		return len(lines) > 0 && lines[0] == "true", nil
 	}
-	return lines, err
 }
 
 func init() {
-	watches = append(watches, func(path, name string) (Repo, error) {
-		git := filepath.Join(path, ".git")
+	addWatch(0, func(entry *Entry, name string) (Repo, error) {
+		if entry.WorkTree != "" {
+			if !isGitDir(entry.Path) {
+				return nil, fmt.Errorf("Work tree \"%s\" given, but \"%s\" is not a git dir", entry.WorkTree, entry.Path)
+			}
+			return newGitRepo(&Git{
+				path:   entry.WorkTree,
+				gitDir: entry.Path,
+				name:   name,
+			})
+		}
+		git := filepath.Join(entry.Path, ".git")
 		if stat, err := os.Stat(git); err != nil {
-			if os.IsNotExist(err) {
-				return nil, nil
-			} else {
+			if !os.IsNotExist(err) {
 				return nil, err
+			} else if bare, err := isBareGitDir(entry.Path, name); err != nil {
+				return nil, err
+			} else if bare {
+				return newGitRepo(&Git{
+					path: entry.Path,
+					name: name,
+					bare: true,
+				})
+			} else {
+				return nil, nil
 			}
 		} else if !stat.IsDir() {
-			return nil, fmt.Errorf("Found \".git\" in \"%s\", but it is not a directory", path)
-		} else {
-			return &Git{
-				path: path,
-				name: name,
-			}, nil
+			if _, err := gitDirFile(entry.Path, git); err != nil {
+				return nil, err
+			}
 		}
+		return newGitRepo(&Git{
+			path: entry.Path,
+			name: name,
+		})
 	})
 }
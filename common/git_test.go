@@ -0,0 +1,266 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseGitTrack(t *testing.T) {
+	tests := []struct {
+		track         string
+		ahead, behind int
+		fail          bool
+	}{
+		{"", 0, 0, false},
+		{"gone", 0, 0, false},
+		{"ahead 1", 1, 0, false},
+		{"behind 2", 0, 2, false},
+		{"ahead 3, behind 12", 3, 12, false},
+		{"ahead x", 0, 0, true},
+		{"ahead", 0, 0, true},
+		{"sideways 1", 0, 0, true},
+	}
+	for _, test := range tests {
+		ahead, behind, err := parseGitTrack(test.track)
+		if (err != nil) != test.fail {
+			t.Errorf("Track \"%s\": expected failure %v, got %v", test.track, test.fail, err)
+		} else if ahead != test.ahead || behind != test.behind {
+			t.Errorf("Track \"%s\": expected %d/%d, got %d/%d", test.track, test.ahead, test.behind, ahead, behind)
+		}
+	}
+}
+
+func TestRewriteGitURL(t *testing.T) {
+	rules := map[string]string{
+		"https://":            "short:",
+		"https://github.com/": "git@github.com:",
+	}
+	tests := []struct {
+		url, expect string
+		rewritten   bool
+	}{
+		{"https://github.com/a/b", "git@github.com:a/b", true},
+		{"https://example.com/a/b", "short:example.com/a/b", true},
+		{"git@github.com:a/b", "git@github.com:a/b", false},
+	}
+	for _, test := range tests {
+		if url, rewritten := rewriteGitURL(test.url, rules); url != test.expect || rewritten != test.rewritten {
+			t.Errorf("URL \"%s\": expected \"%s\" (%v), got \"%s\" (%v)", test.url, test.expect, test.rewritten, url, rewritten)
+		}
+	}
+}
+
+func TestNewGitRemotes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "local.git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "repo")
+	lines := []string{
+		"remote.origin.url git@github.com:me/fork.git",
+		"remote.upstream.url https://github.com/project/repo.git",
+		"remote.upstream.pushurl no_push",
+		"remote.short.url gh:project/repo.git",
+		"url.git@github.com:.insteadof gh:",
+		"url.ssh://git@github.com/.pushinsteadof https://github.com/",
+		"remote.https.url https://github.com/project/other.git",
+		"remote.local.url ../local.git",
+		"remote.gone.url ../gone.git",
+		"remote.mirror.url ../local.git",
+		"remote.mirror.fetch +refs/*:refs/*",
+		"remote.pushmirror.url ../local.git",
+		"remote.pushmirror.mirror",
+		"remote.plain.url https://example.com/x.git",
+		"remote.plain.fetch +refs/heads/*:refs/remotes/plain/*",
+		"remote.plain.mirror false",
+		"remote.daemon.url git://example.com/x.git",
+	}
+	expect := []gitRemote{
+		{"origin", "git@github.com:me/fork.git", "git@github.com:me/fork.git", true, false},
+		{"upstream", "https://github.com/project/repo.git", "no_push", false, false},
+		{"short", "git@github.com:project/repo.git", "git@github.com:project/repo.git", true, false},
+		{"https", "https://github.com/project/other.git", "ssh://git@github.com/project/other.git", true, false},
+		{"local", "../local.git", "../local.git", true, false},
+		{"gone", "../gone.git", "../gone.git", false, false},
+		{"mirror", "../local.git", "../local.git", true, true},
+		{"pushmirror", "../local.git", "../local.git", true, true},
+		{"plain", "https://example.com/x.git", "https://example.com/x.git", true, false},
+		{"daemon", "git://example.com/x.git", "git://example.com/x.git", false, false},
+	}
+	remotes := newGitRemotes(path, lines)
+	if len(remotes) != len(expect) {
+		t.Fatalf("Expected %d remotes, got %d", len(expect), len(remotes))
+	}
+	for i, remote := range remotes {
+		if !reflect.DeepEqual(*remote, expect[i]) {
+			t.Errorf("Expected %+v, got %+v", expect[i], *remote)
+		}
+	}
+}
+
+func TestBranchStatesUnverified(t *testing.T) {
+	branches := []*gitBranch{newGitBranch("master", "1", "origin", "refs/remotes/origin/master")}
+	remotes := []*gitRemote{{name: "origin", pushable: true}, {name: "backup", pushable: true}}
+	refs := map[string]string{"refs/heads/master": "1", "refs/remotes/origin/master": "1"}
+	err := &RemoteError{Remote: "backup", Reason: REMOTE_UNREACHABLE}
+	expect := []*SyncState{
+		{Remote: "origin", Branch: "master", State: SYNC_STATE_SAME},
+		{Remote: "backup", State: SYNC_STATE_UNVERIFIED, Error: err},
+	}
+	count := func(local, remote string) (int, int, error) {
+		return 0, 0, fmt.Errorf("Unexpected count of %s...%s", local, remote)
+	}
+	if states := branchStates(branches, remotes, refs, map[string]error{"backup": err}, count); !reflect.DeepEqual(states, expect) {
+		t.Errorf("Expected %v, got %v", expect, states)
+	}
+}
+
+func TestTagStates(t *testing.T) {
+	tags := map[string]string{"v1": "1", "v2": "2", "mine": "3", "moved": "4"}
+	remotes := []*gitRemote{
+		{name: "origin", pushable: true},
+		{name: "upstream", pushable: false},
+		{name: "unknown", pushable: true},
+		{name: "failed", pushable: true},
+	}
+	refs := map[string]string{
+		"refs/remote-tags/origin/v1":    "1",
+		"refs/remote-tags/origin/moved": "5",
+		"refs/remote-tags/upstream/v1":  "1",
+		"refs/remote-tags/upstream/v2":  "2",
+	}
+	fetched := map[string]error{"failed": fmt.Errorf("Failed")}
+	expect := []*SyncState{
+		{Remote: "origin", Tag: "mine", State: SYNC_STATE_TAG_MISSING},
+		{Remote: "origin", Tag: "moved", State: SYNC_STATE_TAG_DIFFERENT},
+	}
+	if states := tagStates(tags, remotes, refs, fetched); !reflect.DeepEqual(states, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, states)
+	}
+	remotes = append(remotes, &gitRemote{name: "mirror", mirror: true})
+	if states := tagStates(tags, remotes, refs, fetched); len(states) != 0 {
+		t.Errorf("Expected no states with mirror, got %+v", states)
+	}
+}
+
+func TestGitChangeSetLarge(t *testing.T) {
+	dir, git := gitTestRepo(t)
+	git("commit", "-q", "--allow-empty", "-m", "base")
+	for i := 0; i < 3000; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("untracked-file-with-a-long-name-%04d", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := NewRepo(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := repo.(Changed).ChangeSet(); err != nil {
+		t.Fatal(err)
+	} else if len(changes.Untracked) != 3000 {
+		t.Errorf("Expected 3000 untracked files, got %d", len(changes.Untracked))
+	} else if changes.Untracked[2999] != "untracked-file-with-a-long-name-2999" {
+		t.Errorf("Unexpected last untracked file \"%s\"", changes.Untracked[2999])
+	}
+}
+
+func TestStaleRemoteTags(t *testing.T) {
+	remotes := []*gitRemote{{name: "origin"}, {name: "up/stream"}}
+	refs := map[string]string{
+		"refs/heads/master":               "1",
+		"refs/remotes/gone/master":        "1",
+		"refs/remote-tags/origin/v1":      "1",
+		"refs/remote-tags/up/stream/v1":   "1",
+		"refs/remote-tags/up/v1":          "1",
+		"refs/remote-tags/gone/v1":        "1",
+		"refs/remote-tags/origin-fork/v1": "1",
+	}
+	expect := []string{"refs/remote-tags/gone/v1", "refs/remote-tags/origin-fork/v1", "refs/remote-tags/up/v1"}
+	if stale := staleRemoteTags(remotes, refs); !reflect.DeepEqual(stale, expect) {
+		t.Errorf("Expected %v, got %v", expect, stale)
+	}
+	remotes = append(remotes, &gitRemote{name: "mirror", mirror: true})
+	if stale := staleRemoteTags(remotes, refs); len(stale) != 0 {
+		t.Errorf("Expected no stale refs with mirror, got %v", stale)
+	}
+}
+
+func TestGitPrunedRemote(t *testing.T) {
+	dir, git := gitTestRepo(t)
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	git("init", "-q", "--bare", remote)
+	git("remote", "add", "origin", remote)
+	git("commit", "-q", "--allow-empty", "-m", "base")
+	git("push", "-q", "-u", "origin", "HEAD")
+	git("checkout", "-q", "-b", "feature")
+	git("commit", "-q", "--allow-empty", "-m", "feature")
+	git("tag", "v1")
+	git("push", "-q", "-u", "origin", "feature", "v1")
+
+	repo, err := NewRepo(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsynced := func() []*SyncState {
+		states, err := repo.States()
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsynced := make([]*SyncState, 0)
+		for _, state := range states {
+			if state.State != SYNC_STATE_SAME {
+				unsynced = append(unsynced, state)
+			}
+		}
+		return unsynced
+	}
+	if states := unsynced(); len(states) != 0 {
+		t.Fatalf("Expected all in sync, got %+v", states)
+	} else if count, _, err := repo.(Unbacked).Unbacked(); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("Expected no unbacked commits, got %d", count)
+	}
+
+	git("--git-dir", remote, "branch", "-D", "feature")
+	git("--git-dir", remote, "tag", "-d", "v1")
+	expect := []*SyncState{
+		{Remote: "origin", Branch: "feature", State: SYNC_STATE_MISSING},
+		{Remote: "origin", Tag: "v1", State: SYNC_STATE_TAG_MISSING},
+	}
+	if states := unsynced(); !reflect.DeepEqual(states, expect) {
+		t.Errorf("Expected %+v, got %+v", expect, states)
+	}
+	if count, branches, err := repo.(Unbacked).Unbacked(); err != nil {
+		t.Fatal(err)
+	} else if count != 1 || !reflect.DeepEqual(branches, []string{"feature"}) {
+		t.Errorf("Expected 1 unbacked commit in feature, got %d in %v", count, branches)
+	}
+}
+
+// gitTestRepo creates a git repo in a temporary dir and returns a function,
+// which runs git in it. Commits are dated a minute after each other.
+func gitTestRepo(t *testing.T) (string, func(args ...string)) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	commits := 0
+	run := func(args ...string) {
+		commits++
+		date := fmt.Sprintf("@%d +0000", 1600000000+commits*60)
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@localhost"}, args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s %s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	return dir, run
+}
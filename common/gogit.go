@@ -0,0 +1,712 @@
+package common
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoGit is a watch of a Git repository, which reads the status of the work
+// tree, branches, stashes, HEAD and config in-process and counts commits by
+// walking the history. Git is only executed to fetch remotes and for
+// submodules, git-annex and worktrees.
+type (
+	GoGit struct {
+		*Git
+		repo *git.Repository
+
+		// dir is the git dir of the work tree and commonDir the git dir,
+		// which is shared by all worktrees of the repo
+		dir       string
+		commonDir string
+	}
+
+	// commitQueue is a priority queue of commits, the most recent first and
+	// equally recent ones in the order they were pushed
+	commitQueue []*queuedCommit
+
+	queuedCommit struct {
+		*object.Commit
+		seq int
+	}
+)
+
+const (
+	walkLocal uint8 = 1 << iota
+	walkRemote
+	walkBoth = walkLocal | walkRemote
+)
+
+// newGoGit opens the repository of an exec based git watch
+func newGoGit(g *Git) (*GoGit, error) {
+	var repo *git.Repository
+	var err error
+	if g.gitDir != "" {
+		storage := filesystem.NewStorage(osfs.New(g.gitDir), cache.NewObjectLRUDefault())
+		repo, err = git.Open(storage, osfs.New(g.path))
+	} else {
+		repo, err = git.PlainOpenWithOptions(g.path, &git.PlainOpenOptions{
+			EnableDotGitCommonDir: true,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open git repo in \"%s\": %s", g.path, err)
+	}
+	native := &GoGit{
+		Git:  g,
+		repo: repo,
+	}
+	if native.dir, native.commonDir, err = goGitDirs(g); err != nil {
+		return nil, err
+	}
+	return native, nil
+}
+
+// goGitDirs returns the git dir of the work tree and the common git dir of
+// all its worktrees
+func goGitDirs(g *Git) (string, string, error) {
+	dir := g.gitDir
+	if dir == "" && g.bare {
+		dir = g.path
+	} else if dir == "" {
+		dir = filepath.Join(g.path, ".git")
+		if stat, err := os.Stat(dir); err != nil {
+			return "", "", err
+		} else if !stat.IsDir() {
+			if dir, err = gitDirFile(g.path, dir); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	if raw, err := ioutil.ReadFile(filepath.Join(dir, "commondir")); os.IsNotExist(err) {
+		return dir, dir, nil
+	} else if err != nil {
+		return "", "", err
+	} else if common := strings.TrimSpace(string(raw)); filepath.IsAbs(common) {
+		return dir, common, nil
+	} else {
+		return dir, filepath.Join(dir, common), nil
+	}
+}
+
+func (this *GoGit) WithContext(ctx context.Context) Repo {
+	native := *this
+	native.Git = this.Git.withContext(ctx)
+	return &native
+}
+
+func (this *GoGit) Changes() (bool, error) {
+	if changes, err := this.ChangeSet(); err != nil {
+		return false, err
+	} else {
+		return !changes.Empty(), nil
+	}
+}
+
+// ChangeSet returns the changes of the work tree. As git does, untracked dirs
+// without any tracked file are listed instead of their files.
+func (this *GoGit) ChangeSet() (*ChangeSet, error) {
+	changes := &ChangeSet{}
+	if this.bare {
+		return changes, nil
+	}
+	worktree, err := this.repo.Worktree()
+	if err != nil {
+		return nil, err
+	} else if worktree.Excludes, err = this.excludes(); err != nil {
+		return nil, err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	if idx, err := this.repo.Storer.Index(); err != nil {
+		return nil, err
+	} else {
+		for _, entry := range idx.Entries {
+			for dir := path.Dir(entry.Name); dir != "."; dir = path.Dir(dir) {
+				tracked[dir] = true
+			}
+		}
+	}
+	untracked := make(map[string]bool)
+	for file, fs := range status {
+		if fs.Staging == git.Untracked {
+			untracked[untrackedDir(file, tracked)] = true
+		} else if fs.Staging != git.Unmodified || fs.Worktree != git.Unmodified {
+			changes.add(byte(fs.Staging), byte(fs.Worktree), file, fs.Extra)
+		}
+	}
+	for file := range untracked {
+		changes.Untracked = append(changes.Untracked, file)
+	}
+	changes.sort()
+	return changes, nil
+}
+
+// untrackedDir returns the top most dir of an untracked file, which contains
+// no tracked files, or the file itself
+func untrackedDir(file string, tracked map[string]bool) string {
+	parts := strings.Split(file, "/")
+	for i := 1; i < len(parts); i++ {
+		if dir := strings.Join(parts[:i], "/"); !tracked[dir] {
+			return dir + "/"
+		}
+	}
+	return file
+}
+
+// excludes returns the patterns of ignored files, which go-git does not read
+// by itself: the excludes file of the user (core.excludesFile, by default
+// ~/.config/git/ignore) and "info/exclude" of the git dir
+func (this *GoGit) excludes() ([]gitignore.Pattern, error) {
+	file := ""
+	if lines, err := this.configLines(`^core\.excludesfile$`); err != nil {
+		return nil, err
+	} else if len(lines) > 0 {
+		file = strings.TrimPrefix(lines[len(lines)-1], "core.excludesfile ")
+		if strings.Index(file, "~/") == 0 {
+			if home, err := os.UserHomeDir(); err == nil {
+				file = filepath.Join(home, file[2:])
+			}
+		}
+	} else if xdg, err := xdgConfigHome(); err == nil {
+		file = filepath.Join(xdg, "git", "ignore")
+	}
+	patterns := make([]gitignore.Pattern, 0)
+	for _, file := range []string{file, filepath.Join(this.commonDir, "info", "exclude")} {
+		if file == "" {
+			continue
+		} else if raw, err := ioutil.ReadFile(file); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		} else {
+			for _, line := range strings.Split(string(raw), "\n") {
+				if line = strings.TrimSuffix(line, "\r"); strings.TrimSpace(line) != "" && line[0] != '#' {
+					patterns = append(patterns, gitignore.ParsePattern(line, nil))
+				}
+			}
+		}
+	}
+	return patterns, nil
+}
+
+func (this *GoGit) Synced() (SyncStateNum, error) {
+	if states, err := this.States(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else {
+		return SyncedStates(states)
+	}
+}
+func (this *GoGit) States() ([]*SyncState, error) {
+	if remotes, err := this.remotes(); err != nil {
+		return nil, err
+	} else if len(remotes) == 0 {
+		if refs, err := this.refs(); err != nil {
+			return nil, err
+		} else {
+			for name := range refs {
+				if strings.Index(name, "refs/heads/") == 0 {
+					return []*SyncState{{State: SYNC_STATE_NO_REMOTE}}, nil
+				}
+			}
+			return []*SyncState{{State: SYNC_STATE_SAME}}, nil
+		}
+	} else {
+		if fetched, err := this.fetchAll(remotes); err != nil {
+			return nil, err
+		} else if refs, err := this.refs(); err != nil {
+			return nil, err
+		} else if branches, err := this.trackedBranches(refs); err != nil {
+			return nil, err
+		} else {
+			tags := make(map[string]string)
+			hashes := make(map[string]string)
+			for name, hash := range refs {
+				if strings.Index(name, "refs/tags/") == 0 {
+					tags[name[len("refs/tags/"):]] = hash.String()
+				} else {
+					hashes[name] = hash.String()
+				}
+			}
+			for _, ref := range staleRemoteTags(remotes, hashes) {
+				if err := this.repo.Storer.RemoveReference(plumbing.ReferenceName(ref)); err != nil {
+					return nil, err
+				}
+			}
+			states := branchStates(branches, remotes, hashes, fetched, func(local, remote string) (int, int, error) {
+				return this.aheadBehind(refs[local], refs[remote])
+			})
+			return append(states, tagStates(tags, remotes, hashes, fetched)...), nil
+		}
+	}
+}
+
+// Unbacked returns amount of commits reachable from local branches or tags,
+// but not from any remote branch or tag, and the local branches containing
+// them. Mirrors have nothing unbacked, since their local branches are remote.
+func (this *GoGit) Unbacked() (int, []string, error) {
+	if remotes, err := this.remotes(); err != nil {
+		return 0, nil, err
+	} else if hasMirror(remotes) {
+		return 0, []string{}, nil
+	} else if refs, err := this.refs(); err != nil {
+		return 0, nil, err
+	} else {
+		local, remote := make([]plumbing.Hash, 0), make([]plumbing.Hash, 0)
+		for name, hash := range refs {
+			if ref := plumbing.ReferenceName(name); ref.IsBranch() || ref.IsTag() {
+				local = append(local, hash)
+			} else {
+				remote = append(remote, hash)
+			}
+		}
+		flags, err := this.walk(local, remote)
+		if err != nil {
+			return 0, nil, err
+		}
+		count := 0
+		for _, flag := range flags {
+			if flag == walkLocal {
+				count++
+			}
+		}
+		branches := []string{}
+		for name, hash := range refs {
+			if ref := plumbing.ReferenceName(name); ref.IsBranch() && flags[hash] == walkLocal {
+				branches = append(branches, ref.Short())
+			}
+		}
+		sort.Strings(branches)
+		return count, branches, nil
+	}
+}
+
+// Stashes returns all entries of the stash, latest first, which are read from
+// the reflog of the stash
+func (this *GoGit) Stashes() ([]*Stash, error) {
+	stashes := make([]*Stash, 0)
+	if this.bare {
+		return stashes, nil
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(this.commonDir, "logs", "refs", "stash"))
+	if os.IsNotExist(err) {
+		return stashes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(raw), "\n"), "\n")
+	rx := regexp.MustCompile(`^[0-9a-f]+ [0-9a-f]+ .*> (\d+) [-+]\d+\t(.*)$`)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if m := rx.FindStringSubmatch(lines[i]); m == nil {
+			return nil, fmt.Errorf("Failed to parse stash entry \"%s\"", lines[i])
+		} else if ts, err := strconv.ParseInt(m[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("Failed to parse time of stash entry \"%s\": %s", lines[i], err)
+		} else {
+			stashes = append(stashes, &Stash{
+				Name:    fmt.Sprintf("stash@{%d}", len(stashes)),
+				Message: m[2],
+				Time:    time.Unix(ts, 0),
+			})
+		}
+	}
+	return stashes, nil
+}
+
+// Head returns the unfinished operation of the work tree and whether HEAD is
+// detached with commits not reachable from any branch or tag
+func (this *GoGit) Head() (*HeadState, error) {
+	head := &HeadState{}
+	for _, op := range gitOperations {
+		if _, err := os.Stat(filepath.Join(this.dir, op.file)); err == nil {
+			head.Operation = op.operation
+			break
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if ref, err := this.repo.Reference(plumbing.HEAD, false); err != nil {
+		return nil, err
+	} else if ref.Type() == plumbing.SymbolicReference {
+		return head, nil
+	} else if refs, err := this.refs(); err != nil {
+		return nil, err
+	} else {
+		head.Detached = true
+		others := make([]plumbing.Hash, 0)
+		for name, hash := range refs {
+			if strings.Index(name, gitRemoteTags) != 0 {
+				others = append(others, hash)
+			}
+		}
+		if flags, err := this.walk([]plumbing.Hash{ref.Hash()}, others); err != nil {
+			return nil, err
+		} else {
+			for _, flag := range flags {
+				if flag == walkLocal {
+					head.Unreachable++
+				}
+			}
+			return head, nil
+		}
+	}
+}
+
+// LastFetch returns when the remotes were fetched the last time, which is zero
+// if they never were
+func (this *GoGit) LastFetch() (time.Time, error) {
+	if stat, err := os.Stat(filepath.Join(this.dir, "FETCH_HEAD")); os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	} else {
+		return stat.ModTime(), nil
+	}
+}
+
+// Annex returns the state of git-annex, which is only run for repos with an
+// annex.uuid
+func (this *GoGit) Annex() (*AnnexState, error) {
+	if lines, err := this.configLines(`^annex\.uuid$`); err != nil {
+		return nil, err
+	} else if len(lines) == 0 {
+		return nil, nil
+	}
+	return this.Git.Annex()
+}
+
+// fetchAll is Git.fetchAll with the last fetch and the ssh command read
+// in-process
+func (this *GoGit) fetchAll(remotes []*gitRemote) (map[string]error, error) {
+	if due, err := fetchDue(this.name, this.LastFetch); err != nil {
+		return nil, err
+	} else if !due {
+		return make(map[string]error), nil
+	} else {
+		return this.fetchRemotes(remotes, this.sshCommand())
+	}
+}
+
+// remotes returns the remotes read from the config files
+func (this *GoGit) remotes() ([]*gitRemote, error) {
+	if lines, err := this.configLines(gitRemotesConfig); err != nil {
+		return nil, err
+	} else {
+		remotes := newGitRemotes(this.path, lines)
+		for _, remote := range remotes {
+			remote.mirror = remote.mirror && this.bare
+		}
+		return remotes, nil
+	}
+}
+
+// sshCommand is Git.sshCommand with core.sshCommand read from the config files
+func (this *GoGit) sshCommand() string {
+	return gitSSHCommand(func() string {
+		if lines, err := this.configLines(`^core\.sshcommand$`); err == nil && len(lines) > 0 {
+			return strings.TrimPrefix(lines[len(lines)-1], "core.sshcommand ")
+		}
+		return ""
+	})
+}
+
+// configLines returns the config of the system, the user and the repo as
+// printed by "git config --get-regexp" for all keys matching the expression.
+// Includes are followed, conditional includes are not.
+func (this *GoGit) configLines(rx string) ([]string, error) {
+	match, err := regexp.Compile(rx)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		files = append(files, "/etc/gitconfig")
+	}
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		files = append(files, global)
+	} else {
+		if xdg, err := xdgConfigHome(); err == nil {
+			files = append(files, filepath.Join(xdg, "git", "config"))
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			files = append(files, filepath.Join(home, ".gitconfig"))
+		}
+	}
+	files = append(files, filepath.Join(this.commonDir, "config"))
+	lines := make([]string, 0)
+	for _, file := range files {
+		if err := readGitConfig(file, 0, func(key, value string) {
+			if !match.MatchString(key) {
+				return
+			} else if value == "" {
+				lines = append(lines, key)
+			} else {
+				lines = append(lines, key+" "+value)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// readGitConfig calls back with the keys and values of a git config file and
+// the files it includes, which may not exist
+func readGitConfig(file string, depth int, cb func(key, value string)) error {
+	cfg := config.New()
+	if fh, err := os.Open(file); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else {
+		defer fh.Close()
+		if err := config.NewDecoder(fh).Decode(cfg); err != nil {
+			return fmt.Errorf("Failed to read git config \"%s\": %s", file, err)
+		}
+	}
+	for _, section := range cfg.Sections {
+		name := strings.ToLower(section.Name)
+		for _, option := range section.Options {
+			key := name + "." + strings.ToLower(option.Key)
+			if key != "include.path" {
+				cb(key, option.Value)
+				continue
+			} else if depth >= 10 {
+				return fmt.Errorf("Too many nested includes in git config \"%s\"", file)
+			}
+			include := option.Value
+			if strings.Index(include, "~/") == 0 {
+				if home, err := os.UserHomeDir(); err == nil {
+					include = filepath.Join(home, include[2:])
+				}
+			} else if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(file), include)
+			}
+			if err := readGitConfig(include, depth+1, cb); err != nil {
+				return err
+			}
+		}
+		for _, sub := range section.Subsections {
+			for _, option := range sub.Options {
+				cb(name+"."+sub.Name+"."+strings.ToLower(option.Key), option.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// xdgConfigHome returns the base dir of user config files
+func xdgConfigHome() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return xdg, nil
+	} else if home, err := os.UserHomeDir(); err != nil {
+		return "", err
+	} else {
+		return filepath.Join(home, ".config"), nil
+	}
+}
+
+// refs returns (name => object) map of local and remote branches and tags
+func (this *GoGit) refs() (map[string]plumbing.Hash, error) {
+	refs := make(map[string]plumbing.Hash)
+	if iter, err := this.repo.References(); err != nil {
+		return nil, err
+	} else {
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Type() != plumbing.HashReference {
+				return nil
+			} else if name := ref.Name(); name.IsBranch() || name.IsRemote() || name.IsTag() || strings.Index(name.String(), gitRemoteTags) == 0 {
+				refs[ref.Name().String()] = ref.Hash()
+			}
+			return nil
+		})
+		return refs, err
+	}
+}
+
+// trackedBranches returns list of local branches with their configured
+// upstream branches, which are mapped to remote refs by the fetch refspecs
+func (this *GoGit) trackedBranches(refs map[string]plumbing.Hash) ([]*gitBranch, error) {
+	config, err := this.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for name := range refs {
+		if strings.Index(name, "refs/heads/") == 0 {
+			names = append(names, name[len("refs/heads/"):])
+		}
+	}
+	sort.Strings(names)
+	branches := make([]*gitBranch, len(names))
+	for i, name := range names {
+		remote, upstream := "", ""
+		if branch, ok := config.Branches[name]; ok && branch.Merge != "" {
+			remote = branch.Remote
+			if rc, ok := config.Remotes[remote]; ok {
+				for _, spec := range rc.Fetch {
+					if spec.Match(branch.Merge) {
+						upstream = spec.Dst(branch.Merge).String()
+						break
+					}
+				}
+			}
+		}
+		branches[i] = newGitBranch(name, refs["refs/heads/"+name].String(), remote, upstream)
+	}
+	return branches, nil
+}
+
+// aheadBehind returns the amount of commits only reachable from local and
+// only reachable from remote
+func (this *GoGit) aheadBehind(local, remote plumbing.Hash) (ahead int, behind int, err error) {
+	if local == remote {
+		return 0, 0, nil
+	}
+	flags, err := this.walk([]plumbing.Hash{local}, []plumbing.Hash{remote})
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, flag := range flags {
+		if flag == walkLocal {
+			ahead++
+		} else if flag == walkRemote {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// walk returns the commits reachable from the local and the remote refs,
+// flagged by the sides they are reachable from. The history is walked from
+// both sides, the most recent commits first, until all commits in the queue
+// are reachable from both and older than any commit found reachable from only
+// one side. Commits reached again from the other side are walked again.
+// Commits reachable from both sides, which were not walked, are missing.
+func (this *GoGit) walk(local, remote []plumbing.Hash) (map[plumbing.Hash]uint8, error) {
+	flags := make(map[plumbing.Hash]uint8)
+	queued := make(map[plumbing.Hash]bool)
+	queue := &commitQueue{}
+	interesting, seq := 0, 0
+	push := func(hash plumbing.Hash, flag uint8) error {
+		old := flags[hash]
+		if old|flag == old {
+			return nil
+		} else if queued[hash] {
+			if flags[hash] = old | flag; flags[hash] == walkBoth {
+				interesting--
+			}
+			return nil
+		}
+		commit, err := this.repo.CommitObject(hash)
+		if err == plumbing.ErrObjectNotFound {
+			return nil // shallow clone
+		} else if err != nil {
+			return err
+		}
+		flags[hash] = old | flag
+		queued[hash] = true
+		seq++
+		heap.Push(queue, &queuedCommit{commit, seq})
+		if flags[hash] != walkBoth {
+			interesting++
+		}
+		return nil
+	}
+	for _, refs := range []struct {
+		hashes []plumbing.Hash
+		flag   uint8
+	}{{local, walkLocal}, {remote, walkRemote}} {
+		for _, hash := range refs.hashes {
+			if hash, err := this.peel(hash); err != nil {
+				return nil, err
+			} else if hash.IsZero() {
+				continue
+			} else if err = push(hash, refs.flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var oldest time.Time
+	for queue.Len() > 0 && (interesting > 0 || !oldest.IsZero() && !(*queue)[0].Committer.When.Before(oldest)) {
+		if this.ctx != nil && this.ctx.Err() != nil {
+			return nil, this.ctx.Err()
+		}
+		commit := heap.Pop(queue).(*queuedCommit)
+		delete(queued, commit.Hash)
+		flag := flags[commit.Hash]
+		if flag != walkBoth {
+			interesting--
+			if oldest.IsZero() || commit.Committer.When.Before(oldest) {
+				oldest = commit.Committer.When
+			}
+		}
+		for _, parent := range commit.ParentHashes {
+			if err := push(parent, flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return flags, nil
+}
+
+// peel returns the commit of a ref, which may be an annotated tag, or a zero
+// hash if it does not point to a commit
+func (this *GoGit) peel(hash plumbing.Hash) (plumbing.Hash, error) {
+	for {
+		if tag, err := this.repo.TagObject(hash); err == plumbing.ErrObjectNotFound {
+			return hash, nil
+		} else if err != nil {
+			return plumbing.ZeroHash, err
+		} else if tag.TargetType != plumbing.CommitObject && tag.TargetType != plumbing.TagObject {
+			return plumbing.ZeroHash, nil
+		} else {
+			hash = tag.Target
+		}
+	}
+}
+
+func (this commitQueue) Len() int {
+	return len(this)
+}
+
+func (this commitQueue) Less(i, j int) bool {
+	if this[i].Committer.When.Equal(this[j].Committer.When) {
+		return this[i].seq < this[j].seq
+	}
+	return this[i].Committer.When.After(this[j].Committer.When)
+}
+
+func (this commitQueue) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+func (this *commitQueue) Push(x interface{}) {
+	*this = append(*this, x.(*queuedCommit))
+}
+
+func (this *commitQueue) Pop() interface{} {
+	old := *this
+	commit := old[len(old)-1]
+	*this = old[:len(old)-1]
+	return commit
+}
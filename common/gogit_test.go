@@ -0,0 +1,140 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoGitAheadBehind(t *testing.T) {
+	dir, git := gitTestRepo(t)
+	git("commit", "-q", "--allow-empty", "-m", "base")
+	git("branch", "a")
+	git("branch", "b")
+	git("checkout", "-q", "a")
+	git("commit", "-q", "--allow-empty", "-m", "a1")
+	git("commit", "-q", "--allow-empty", "-m", "a2")
+	git("checkout", "-q", "b")
+	git("commit", "-q", "--allow-empty", "-m", "b1")
+	git("merge", "-q", "--no-ff", "-m", "merge a", "a")
+	git("commit", "-q", "--allow-empty", "-m", "b2")
+	git("checkout", "-q", "a")
+	git("commit", "-q", "--allow-empty", "-m", "a3")
+	git("checkout", "-q", "-b", "c", "b")
+	git("merge", "-q", "--no-ff", "-m", "merge a again", "a")
+	git("checkout", "-q", "-b", "d", "HEAD~1")
+	for i := 0; i < 5; i++ {
+		git("commit", "-q", "--allow-empty", "-m", "d")
+	}
+
+	repo := &Git{name: "test", path: dir}
+	native, err := newGoGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, err := native.refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	branches := []string{"refs/heads/master", "refs/heads/a", "refs/heads/b", "refs/heads/c", "refs/heads/d"}
+	if _, ok := refs["refs/heads/master"]; !ok {
+		branches[0] = "refs/heads/main"
+	}
+	for _, local := range branches {
+		for _, remote := range branches {
+			ahead, behind, err := native.aheadBehind(refs[local], refs[remote])
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectAhead, expectBehind, err := repo.aheadBehind(local, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ahead != expectAhead || behind != expectBehind {
+				t.Errorf("%s...%s: expected %d/%d, got %d/%d", local, remote, expectAhead, expectBehind, ahead, behind)
+			}
+		}
+	}
+}
+
+func TestGoGitMatchesGit(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", "")
+	dir, git := gitTestRepo(t)
+	write := func(file, content string) {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(home, "xdg", "git", "ignore"), "*.log\n")
+	write(".git/info/exclude", "*.tmp\n")
+	write(".git/extra.config", "[remote \"backup\"]\n\turl = /nonexistent\n")
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	git("init", "-q", "--bare", remote)
+	git("remote", "add", "origin", remote)
+	git("config", "include.path", "extra.config")
+
+	write("a", "a")
+	write("b", "b")
+	git("add", "a", "b")
+	git("commit", "-q", "-m", "base")
+	git("push", "-q", "-u", "origin", "HEAD")
+	write("a", "stash 1")
+	git("stash", "-q")
+	write("b", "stash 2")
+	git("stash", "-q")
+	git("checkout", "-q", "-b", "feature")
+	git("commit", "-q", "--allow-empty", "-m", "unpushed")
+	git("tag", "-a", "-m", "tag", "v1")
+	git("checkout", "-q", "--detach")
+	git("commit", "-q", "--allow-empty", "-m", "detached 1")
+	git("commit", "-q", "--allow-empty", "-m", "detached 2")
+	git("bisect", "start")
+	write("a", "modified")
+	write("b", "staged")
+	git("add", "b")
+	write("new/x", "x")
+	write("new/y", "y")
+	write("c.log", "ignored")
+	write("d.tmp", "ignored")
+
+	repo := &Git{name: "test", path: dir}
+	native, err := newGoGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compare := func(name string, expect func() (interface{}, error), actual func() (interface{}, error)) {
+		if e, err := expect(); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		} else if a, err := actual(); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !reflect.DeepEqual(a, e) {
+			t.Errorf("%s: expected %+v, got %+v", name, e, a)
+		}
+	}
+	compare("ChangeSet",
+		func() (interface{}, error) { return repo.ChangeSet() },
+		func() (interface{}, error) { return native.ChangeSet() })
+	compare("Stashes",
+		func() (interface{}, error) { return repo.Stashes() },
+		func() (interface{}, error) { return native.Stashes() })
+	compare("Head",
+		func() (interface{}, error) { return repo.Head() },
+		func() (interface{}, error) { return native.Head() })
+	compare("Unbacked",
+		func() (interface{}, error) { c, b, err := repo.Unbacked(); return []interface{}{c, b}, err },
+		func() (interface{}, error) { c, b, err := native.Unbacked(); return []interface{}{c, b}, err })
+	compare("remotes",
+		func() (interface{}, error) { return repo.remotes() },
+		func() (interface{}, error) { return native.remotes() })
+}
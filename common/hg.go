@@ -0,0 +1,304 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Hg is a watch of a Mercurial repository
+type (
+	Hg struct {
+		name string
+		path string
+		ctx  context.Context
+
+		// ssh is the ssh command in batch mode, see sshCommand
+		ssh string
+	}
+
+	hgPath struct {
+		name string
+		url  string
+	}
+)
+
+func (this *Hg) Changes() (bool, error) {
+	if lines, err := this.exec("status"); err != nil {
+		return false, err
+	} else {
+		return len(lines) > 0, nil
+	}
+}
+
+// ChangeSet returns the changes of the working directory. Added and removed
+// files are considered staged, since they are scheduled for the next commit.
+func (this *Hg) ChangeSet() (*ChangeSet, error) {
+	changes := &ChangeSet{}
+	if lines, err := this.exec("status"); err != nil {
+		return nil, err
+	} else if unresolved, err := this.exec("resolve", "--list"); err != nil {
+		return nil, err
+	} else {
+		for _, line := range unresolved {
+			if strings.Index(line, "U ") == 0 {
+				changes.Conflicted = append(changes.Conflicted, filepath.ToSlash(line[2:]))
+			}
+		}
+		for _, line := range lines {
+			if len(line) < 3 {
+				continue
+			}
+			file := filepath.ToSlash(line[2:])
+			switch line[0] {
+			case 'M':
+				changes.Modified = append(changes.Modified, file)
+			case 'A':
+				changes.Staged = append(changes.Staged, file)
+			case 'R', '!':
+				changes.Deleted = append(changes.Deleted, file)
+			case '?':
+				changes.Untracked = append(changes.Untracked, file)
+			}
+		}
+		changes.sort()
+		return changes, nil
+	}
+}
+
+func (this *Hg) Remotes() ([]string, error) {
+	if paths, err := this.paths(); err != nil {
+		return nil, err
+	} else {
+		urls := make([]string, len(paths))
+		for i, path := range paths {
+			urls[i] = path.url
+		}
+		return urls, nil
+	}
+}
+
+func (this *Hg) Synced() (SyncStateNum, error) {
+	if states, err := this.States(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else {
+		return SyncedStates(states)
+	}
+}
+
+func (this *Hg) States() ([]*SyncState, error) {
+	if paths, err := this.paths(); err != nil {
+		return nil, err
+	} else if len(paths) == 0 {
+		state, err := this.noRemoteState()
+		return []*SyncState{{State: state, Error: err}}, nil
+	} else if NoFetch {
+		return nil, ErrOffline
+	} else {
+		states := make([]*SyncState, 0)
+		for _, path := range paths {
+			state := &SyncState{
+				Remote: path.name,
+			}
+			var err error
+			if state.Ahead, err = this.outgoing(path); err != nil {
+				state.State = SYNC_STATE_FAIL
+				state.Error = err
+			} else if state.Behind, err = this.incoming(path); err != nil {
+				state.State = SYNC_STATE_FAIL
+				state.Error = err
+			} else {
+				state.State = aheadBehindState(state.Ahead, state.Behind)
+			}
+			states = append(states, state)
+		}
+
+		// bookmarks are only compared against the default path, as does push,
+		// and not at all without default path
+		if path := defaultHgPath(paths); path.url == "" {
+			return states, nil
+		} else if bookmarks, err := this.bookmarks(); err != nil {
+			return nil, err
+		} else if len(bookmarks) > 0 {
+			if remoteBookmarks, err := this.remoteBookmarks(path); err != nil {
+				states = append(states, &SyncState{
+					Remote: "default",
+					State:  SYNC_STATE_FAIL,
+					Error:  err,
+				})
+			} else {
+				for _, bookmark := range bookmarks {
+					if _, ok := remoteBookmarks[bookmark]; !ok {
+						states = append(states, &SyncState{
+							Remote: "default",
+							Branch: bookmark,
+							State:  SYNC_STATE_MISSING,
+						})
+					}
+				}
+			}
+		}
+		return states, nil
+	}
+}
+
+func (this *Hg) WithContext(ctx context.Context) Repo {
+	hg := *this
+	hg.ctx = ctx
+	return &hg
+}
+
+func (this *Hg) Type() string {
+	return "Hg"
+}
+
+func (this *Hg) Updates() (bool, error) {
+	if NoFetch {
+		return false, ErrOffline
+	} else if paths, err := this.paths(); err != nil {
+		return false, err
+	} else if path := defaultHgPath(paths); path.url == "" {
+		return false, nil
+	} else if incoming, err := this.incoming(path); err != nil {
+		return false, err
+	} else {
+		return incoming > 0, nil
+	}
+}
+
+// noRemoteState returns the state of a repo without paths, which is only in
+// sync, if there is nothing to back up
+func (this *Hg) noRemoteState() (SyncStateNum, error) {
+	if lines, err := this.exec("log", "--limit", "1", "--template", "{node}\n"); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if len(lines) > 0 {
+		return SYNC_STATE_NO_REMOTE, nil
+	} else {
+		return SYNC_STATE_SAME, nil
+	}
+}
+
+// outgoing returns amount of local changesets, which are not in remote path
+func (this *Hg) outgoing(path *hgPath) (int, error) {
+	return this.count(path, "outgoing", "--quiet", path.name)
+}
+
+// incoming returns amount of remote changesets, which are not in local repo
+func (this *Hg) incoming(path *hgPath) (int, error) {
+	return this.count(path, "incoming", "--quiet", path.name)
+}
+
+// count returns amount of lines of a command contacting the remote path, which
+// silently exits with 1 if there is nothing to report (as incoming and
+// outgoing do)
+func (this *Hg) count(path *hgPath, args ...string) (int, error) {
+	if lines, err := this.remoteExec(path, args...); err != nil {
+		if exitCode(err) == 1 {
+			return 0, nil
+		}
+		return 0, err
+	} else {
+		return len(lines), nil
+	}
+}
+
+// bookmarks returns list of local bookmarks
+func (this *Hg) bookmarks() ([]string, error) {
+	return this.exec("bookmarks", "--template", "{bookmark}\n")
+}
+
+// remoteBookmarks returns set of bookmarks existing in remote path
+func (this *Hg) remoteBookmarks(path *hgPath) (map[string]bool, error) {
+	if lines, err := this.remoteExec(path, "debugpushkey", path.url, "bookmarks"); err != nil {
+		return nil, err
+	} else {
+		bookmarks := map[string]bool{}
+		for _, line := range lines {
+			if p := strings.SplitN(line, "\t", 2); len(p) == 2 {
+				bookmarks[p[0]] = true
+			}
+		}
+		return bookmarks, nil
+	}
+}
+
+// paths returns list of configured remote paths
+func (this *Hg) paths() ([]*hgPath, error) {
+	if lines, err := this.exec("paths"); err != nil {
+		return nil, err
+	} else {
+		paths := make([]*hgPath, 0)
+		for _, line := range lines {
+			if p := strings.SplitN(line, " = ", 2); len(p) == 2 {
+				paths = append(paths, &hgPath{
+					name: strings.TrimSpace(p[0]),
+					url:  strings.TrimSpace(p[1]),
+				})
+			}
+		}
+		return paths, nil
+	}
+}
+
+// defaultHgPath returns the default path, which is used by push and pull, or
+// a path without URL if there is none
+func defaultHgPath(paths []*hgPath) *hgPath {
+	for _, path := range paths {
+		if path.name == "default" {
+			return path
+		}
+	}
+	return &hgPath{name: "default"}
+}
+
+// remoteExec runs hg command contacting the remote path, within the limit of
+// concurrent commands of its host
+func (this *Hg) remoteExec(path *hgPath, args ...string) (lines []string, err error) {
+	ssh := this.sshCommand()
+	err = withHost(this.ctx, path.url, func() error {
+		lines, err = this.exec(append([]string{"--config", "ui.ssh=" + ssh}, args...)...)
+		return err
+	})
+	return
+}
+
+// sshCommand returns the configured ssh command in batch mode, so that it
+// fails instead of asking for passwords or confirmation of host keys
+func (this *Hg) sshCommand() string {
+	if this.ssh == "" {
+		if lines, err := this.exec("config", "ui.ssh"); err == nil && len(lines) > 0 {
+			this.ssh = lines[0] + " -o BatchMode=yes"
+		} else {
+			this.ssh = "ssh -o BatchMode=yes"
+		}
+	}
+	return this.ssh
+}
+
+// exec runs hg command non-interactively and returns the lines printed to
+// STDOUT
+func (this *Hg) exec(args ...string) ([]string, error) {
+	return execLines(this.ctx, "hg", this.name, this.path, []string{"HGPLAIN=1"}, append([]string{"--config", "ui.interactive=no"}, args...)...)
+}
+
+func init() {
+	addWatch(0, func(entry *Entry, name string) (Repo, error) {
+		hg := filepath.Join(entry.Path, ".hg")
+		if stat, err := os.Stat(hg); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			} else {
+				return nil, err
+			}
+		} else if !stat.IsDir() {
+			return nil, fmt.Errorf("Found \".hg\" in \"%s\", but it is not a directory", entry.Path)
+		} else {
+			return &Hg{
+				path: entry.Path,
+				name: name,
+			}, nil
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// HostJobs is the maximum of concurrent commands contacting the same
+	// remote host, if set
+	HostJobs int
+
+	// hostSlots contains a semaphore per remote host
+	hostSlots    = make(map[string]chan bool)
+	hostSlotsMux sync.Mutex
+
+	// rxScpUrl matches scp-like urls, eg "git@github.com:ukautz/repos.git"
+	rxScpUrl = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+)
+
+// remoteHost returns the host of a remote URL or an empty string for local
+// paths
+func remoteHost(remote string) string {
+	if strings.Contains(remote, "://") {
+		if u, err := url.Parse(remote); err == nil {
+			return strings.ToLower(u.Hostname())
+		}
+		return ""
+	} else if m := rxScpUrl.FindStringSubmatch(remote); m != nil && len(m[1]) > 1 {
+		return strings.ToLower(m[1])
+	} else {
+		return ""
+	}
+}
+
+// withHost runs the callback, when less than HostJobs commands are contacting
+// the host of the remote URL. It returns the error of the context, if it is
+// done while waiting. The context may be nil.
+func withHost(ctx context.Context, remote string, cb func() error) error {
+	host := remoteHost(remote)
+	if HostJobs <= 0 || host == "" {
+		return cb()
+	} else if ctx == nil {
+		ctx = context.Background()
+	}
+	hostSlotsMux.Lock()
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan bool, HostJobs)
+		hostSlots[host] = slots
+	}
+	hostSlotsMux.Unlock()
+	select {
+	case slots <- true:
+		defer func() {
+			<-slots
+		}()
+		return cb()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,245 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Jj is a watch of a Jujutsu repository
+type (
+	Jj struct {
+		name string
+		path string
+		ctx  context.Context
+	}
+
+	jjRemote struct {
+		name string
+		url  string
+	}
+
+	jjBookmark struct {
+		name   string
+		remote string
+
+		// tracked is whether the remote bookmark is tracked by a local bookmark
+		tracked bool
+
+		// ahead and behind are the amount of commits the remote bookmark is
+		// ahead and behind of the tracking local bookmark
+		ahead, behind int
+	}
+)
+
+// jjBookmarkTemplate renders a bookmark as tab separated line of name, remote
+// and, for tracked remote bookmarks, the ahead and behind commit counts
+const jjBookmarkTemplate = `name ++ "\t" ++ remote ++ "\t" ++ if(remote, if(tracked, tracking_ahead_count.lower() ++ "\t" ++ tracking_behind_count.lower(), "-\t-"), "-\t-") ++ "\n"`
+
+// Changes checks whether the working copy commit contains any changes
+func (this *Jj) Changes() (bool, error) {
+	if lines, err := this.exec("diff", "--summary", "--revisions", "@"); err != nil {
+		return false, err
+	} else {
+		return len(lines) > 0, nil
+	}
+}
+
+func (this *Jj) Remotes() ([]string, error) {
+	if remotes, err := this.remotes(); err != nil {
+		return nil, err
+	} else {
+		urls := make([]string, len(remotes))
+		for i, remote := range remotes {
+			urls[i] = remote.url
+		}
+		return urls, nil
+	}
+}
+
+func (this *Jj) Synced() (SyncStateNum, error) {
+	if states, err := this.States(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else {
+		return SyncedStates(states)
+	}
+}
+
+func (this *Jj) States() ([]*SyncState, error) {
+	if remotes, err := this.remotes(); err != nil {
+		return nil, err
+	} else if len(remotes) == 0 {
+		state, err := this.noRemoteState()
+		return []*SyncState{{State: state, Error: err}}, nil
+	} else if err := this.fetch(remotes); err != nil {
+		return nil, err
+	} else if bookmarks, err := this.bookmarks(); err != nil {
+		return nil, err
+	} else {
+		states := make([]*SyncState, 0)
+		tracked := make(map[string]bool)
+		for _, bookmark := range bookmarks {
+			if bookmark.remote == "" || !bookmark.tracked {
+				continue
+			}
+			tracked[bookmark.name] = true
+			states = append(states, &SyncState{
+				Remote: bookmark.remote,
+				Branch: bookmark.name,
+				State:  aheadBehindState(bookmark.behind, bookmark.ahead),
+				Ahead:  bookmark.behind,
+				Behind: bookmark.ahead,
+			})
+		}
+		for _, bookmark := range bookmarks {
+			if bookmark.remote == "" && !tracked[bookmark.name] {
+				states = append(states, &SyncState{
+					Branch: bookmark.name,
+					State:  SYNC_STATE_UNTRACKED,
+				})
+			}
+		}
+		return states, nil
+	}
+}
+
+func (this *Jj) WithContext(ctx context.Context) Repo {
+	jj := *this
+	jj.ctx = ctx
+	return &jj
+}
+
+func (this *Jj) Type() string {
+	return "Jj"
+}
+
+func (this *Jj) Updates() (bool, error) {
+	return false, nil
+}
+
+// noRemoteState returns the state of a repo without remotes, which is only in
+// sync, if there is nothing to back up: no commit besides the root and empty
+// working copy commits without description
+func (this *Jj) noRemoteState() (SyncStateNum, error) {
+	if lines, err := this.exec("log", "--no-graph", "--limit", "1", "--revisions", `all() ~ root() ~ (empty() & description(exact:""))`, "--template", `commit_id ++ "\n"`); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if len(lines) > 0 {
+		return SYNC_STATE_NO_REMOTE, nil
+	} else {
+		return SYNC_STATE_SAME, nil
+	}
+}
+
+// fetch fetches all remotes, each within the limit of concurrent commands of
+// its host, unless fetching is disabled
+func (this *Jj) fetch(remotes []*jjRemote) error {
+	if NoFetch {
+		return nil
+	}
+	env := this.fetchEnv()
+	for _, remote := range remotes {
+		if err := withHost(this.ctx, remote.url, func() error {
+			_, err := this.execEnv(env, "git", "fetch", "--remote", remote.name)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remotes returns list of the git remotes
+func (this *Jj) remotes() ([]*jjRemote, error) {
+	if lines, err := this.exec("git", "remote", "list"); err != nil {
+		return nil, err
+	} else {
+		remotes := make([]*jjRemote, 0)
+		for _, line := range lines {
+			if p := strings.Fields(line); len(p) == 2 {
+				remotes = append(remotes, &jjRemote{
+					name: p[0],
+					url:  p[1],
+				})
+			}
+		}
+		return remotes, nil
+	}
+}
+
+// bookmarks returns list of local and remote bookmarks, excluding the
+// bookmarks of the colocated git repo
+func (this *Jj) bookmarks() ([]*jjBookmark, error) {
+	if lines, err := this.exec("bookmark", "list", "--all-remotes", "--template", jjBookmarkTemplate); err != nil {
+		return nil, err
+	} else {
+		bookmarks := make([]*jjBookmark, 0)
+		for _, line := range lines {
+			p := strings.Split(line, "\t")
+			if len(p) != 4 || p[1] == "git" {
+				continue
+			}
+			bookmark := &jjBookmark{
+				name:   p[0],
+				remote: p[1],
+			}
+			if p[2] != "-" {
+				bookmark.tracked = true
+				if bookmark.ahead, err = strconv.Atoi(p[2]); err != nil {
+					return nil, fmt.Errorf("Failed to parse bookmark %s@%s: %s", p[0], p[1], err)
+				} else if bookmark.behind, err = strconv.Atoi(p[3]); err != nil {
+					return nil, fmt.Errorf("Failed to parse bookmark %s@%s: %s", p[0], p[1], err)
+				}
+			}
+			bookmarks = append(bookmarks, bookmark)
+		}
+		return bookmarks, nil
+	}
+}
+
+// fetchEnv makes git, as run by jj for fetching, fail instead of prompting for
+// credentials, as it does for Git
+func (this *Jj) fetchEnv() []string {
+	env := append([]string{}, gitEnv...)
+	git := &Git{name: this.name, path: this.path, ctx: this.ctx}
+	if command := git.sshCommand(); command != "" {
+		env = append(env, "GIT_SSH_COMMAND="+command)
+	}
+	return env
+}
+
+// exec runs jj command and returns the lines printed to STDOUT
+func (this *Jj) exec(args ...string) ([]string, error) {
+	return this.execEnv(nil, args...)
+}
+
+// execEnv runs jj command with additional environment variables and returns
+// the lines printed to STDOUT
+func (this *Jj) execEnv(env []string, args ...string) ([]string, error) {
+	return execLines(this.ctx, "jj", this.name, this.path, env, append([]string{"--no-pager", "--color", "never"}, args...)...)
+}
+
+func init() {
+
+	// higher priority than git, since jj repos are often colocated with a git
+	// repo, which has a detached HEAD and is misleading about its state
+	addWatch(10, func(entry *Entry, name string) (Repo, error) {
+		jj := filepath.Join(entry.Path, ".jj")
+		if stat, err := os.Stat(jj); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			} else {
+				return nil, err
+			}
+		} else if !stat.IsDir() {
+			return nil, fmt.Errorf("Found \".jj\" in \"%s\", but it is not a directory", entry.Path)
+		} else {
+			return &Jj{
+				path: entry.Path,
+				name: name,
+			}, nil
+		}
+	})
+}
@@ -16,56 +16,159 @@ type (
 		// path is where the list is persisted (JSON file)
 		path string
 
-		// repos contain a (name => path) map of all watched repos
-		repos map[string]string
+		// repos contain a (name => entry) map of all watched repos
+		repos map[string]*Entry
 	}
 
+	// Entry is the persisted registration of a single repo
+	Entry struct {
+
+		// Path is the directory of the repo. For repos with a separate work
+		// tree it is the git dir (eg bare repos)
+		Path string `json:"path"`
+
+		// WorkTree is an optional, separate work tree
+		WorkTree string `json:"work_tree,omitempty"`
+
+		// Ignore contains findings which are not reported for the repo
+		Ignore Ignore `json:"ignore,omitempty"`
+
+		// IgnorePaths contains patterns of files, whose changes are not
+		// reported for the repo, eg "*.log"
+		IgnorePaths []string `json:"ignore_paths,omitempty"`
+	}
+
+	// Ignore is a list of findings, which are not reported for a repo. See
+	// Ignorables
+	Ignore []string
+
 	// Info represents full information about a single repo
 	Info struct {
-		Name, Path, Type string
-		Error            error
-		Repo             Repo
+		Name, Path, WorkTree, Type string
+		Ignore                     Ignore
+		IgnorePaths                []string
+		Error                      error
+		Repo                       Repo
+
+		// Linked is whether the repo is a linked worktree of another checked
+		// repo, with which it shares branches, tags, remotes and stashes
+		Linked bool
 	}
 )
 
+const (
+	// IGNORE_NO_REMOTE does not report repos without any remote
+	IGNORE_NO_REMOTE = "no-remote"
+
+	// IGNORE_UNTRACKED does not report untracked files as local changes
+	IGNORE_UNTRACKED = "untracked"
+
+	// IGNORE_STASH does not report stashed changes
+	IGNORE_STASH = "stash"
+)
+
+// Ignorables describes all findings, which can be ignored per repo
+var Ignorables = map[string]string{
+	IGNORE_NO_REMOTE: "Repo has no remote, eg intentional scratch repos",
+	IGNORE_UNTRACKED: "Untracked files are no local changes, eg generated files not covered by ignore rules",
+	IGNORE_STASH:     "Stashed changes, eg kept deliberately as scratch pad",
+}
+
+// Has checks whether finding is ignored
+func (this Ignore) Has(finding string) bool {
+	for _, f := range this {
+		if f == finding {
+			return true
+		}
+	}
+	return false
+}
+
+// Set adds (or removes) ignored finding
+func (this Ignore) Set(finding string, ignore bool) Ignore {
+	ignored := Ignore{}
+	for _, f := range this {
+		if f != finding {
+			ignored = append(ignored, f)
+		}
+	}
+	if ignore {
+		ignored = append(ignored, finding)
+		sort.Strings(ignored)
+	}
+	return ignored
+}
+
+// MarshalJSON persists entries, which only consist of a path, as plain string
+// to stay compatible with older stores
+func (this *Entry) MarshalJSON() ([]byte, error) {
+	if this.WorkTree == "" && len(this.Ignore) == 0 && len(this.IgnorePaths) == 0 {
+		return json.Marshal(this.Path)
+	}
+	type entry Entry
+	return json.Marshal((*entry)(this))
+}
+
+// UnmarshalJSON reads entries from plain string path or object
+func (this *Entry) UnmarshalJSON(raw []byte) error {
+	if len(raw) > 0 && raw[0] == '"' {
+		return json.Unmarshal(raw, &this.Path)
+	}
+	type entry Entry
+	return json.Unmarshal(raw, (*entry)(this))
+}
+
 // NewList constructs new List instance
 func NewList(path string) *List {
 	return &List{
 		path:  path,
-		repos: make(map[string]string),
+		repos: make(map[string]*Entry),
 	}
 }
 
 // Add includes given named repo under path to watches
 func (this *List) Add(name, path string) (Repo, error) {
-	if w, err := NewRepo(path, name); err != nil {
+	return this.AddEntry(name, &Entry{Path: path})
+}
+
+// AddEntry includes given named repo entry to watches
+func (this *List) AddEntry(name string, entry *Entry) (Repo, error) {
+	if w, err := NewRepoFromEntry(entry, name); err != nil {
 		return nil, err
 	} else {
-		this.repos[name] = path
+		this.repos[name] = entry
 		return w, nil
 	}
 }
 
 // Get returns path of registered repo or empty string
 func (this *List) Get(name string) string {
-	if path, ok := this.repos[name]; ok {
-		return path
+	if entry, ok := this.repos[name]; ok {
+		return entry.Path
 	} else {
 		return ""
 	}
 }
 
+// Entry returns entry of registered repo or nil
+func (this *List) Entry(name string) *Entry {
+	return this.repos[name]
+}
+
 // Info returns Repo
 func (this *List) Info(name string) (*Info, error) {
-	if path, ok := this.repos[name]; ok {
-		if repo, err := NewRepo(path, name); err != nil {
+	if entry, ok := this.repos[name]; ok {
+		if repo, err := NewRepoFromEntry(entry, name); err != nil {
 			return nil, err
 		} else {
 			return &Info{
-				Name: name,
-				Path: path,
-				Type: repo.Type(),
-				Repo: repo,
+				Name:        name,
+				Path:        entry.Path,
+				WorkTree:    entry.WorkTree,
+				Ignore:      entry.Ignore,
+				IgnorePaths: entry.IgnorePaths,
+				Type:        repo.Type(),
+				Repo:        repo,
 			}, nil
 		}
 	} else {
@@ -82,11 +185,15 @@ func (this *List) List() []*Info {
 	sort.Strings(names)
 	named := make([]*Info, len(names))
 	for i, name := range names {
+		entry := this.repos[name]
 		named[i] = &Info{
-			Name: name,
-			Path: this.repos[name],
+			Name:        name,
+			Path:        entry.Path,
+			WorkTree:    entry.WorkTree,
+			Ignore:      entry.Ignore,
+			IgnorePaths: entry.IgnorePaths,
 		}
-		if watch, err := NewRepo(this.repos[name], name); err != nil {
+		if watch, err := NewRepoFromEntry(entry, name); err != nil {
 			named[i].Type = "UNDEF"
 			named[i].Error = err
 		} else {
@@ -111,7 +218,7 @@ func (this *List) Persist() error {
 // Refresh reads watch list from previously persisted storage. Does not error
 // if storage does not exist.
 func (this *List) Refresh() error {
-	m := make(map[string]string)
+	m := make(map[string]*Entry)
 	if raw, err := ioutil.ReadFile(this.path); err != nil {
 		if os.IsNotExist(err) {
 			this.repos = m
@@ -140,7 +247,7 @@ func (this *List) Remove(name string) bool {
 // Watch returns name if path is already watched and empty string if it's not
 func (this *List) Watched(path string) string {
 	for name, watch := range this.repos {
-		if watch == path {
+		if watch.Path == path {
 			return name
 		}
 	}
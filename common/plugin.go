@@ -0,0 +1,241 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/ukautz/repos/common/debug"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plugin is a watch implemented by an external executable named
+// "repos-backend-<name>", found in PATH. Each method runs the executable with
+// a JSON request on STDIN and reads a JSON response from STDOUT.
+type (
+	Plugin struct {
+		name    string
+		path    string
+		backend string
+		command string
+		ctx     context.Context
+	}
+
+	// pluginRequest is written to STDIN of the plugin
+	pluginRequest struct {
+		Command string `json:"command"`
+		Path    string `json:"path"`
+		Name    string `json:"name"`
+	}
+
+	// pluginResponse is read from STDOUT of the plugin. Result depends on the
+	// command: bool for detect, changes and updates, list of strings for
+	// remotes and list of pluginState for states.
+	pluginResponse struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error,omitempty"`
+	}
+
+	// pluginState is a SyncState as returned by the plugin
+	pluginState struct {
+		Remote string `json:"remote"`
+		Branch string `json:"branch"`
+		State  string `json:"state"`
+		Error  string `json:"error,omitempty"`
+		Ahead  int    `json:"ahead,omitempty"`
+		Behind int    `json:"behind,omitempty"`
+	}
+)
+
+const pluginPrefix = "repos-backend-"
+
+var (
+	// pluginStates maps state names of the plugin protocol
+	pluginStates = map[string]SyncStateNum{
+		"fail":       SYNC_STATE_FAIL,
+		"same":       SYNC_STATE_SAME,
+		"behind":     SYNC_STATE_BEHIND,
+		"ahead":      SYNC_STATE_AHEAD,
+		"diverged":   SYNC_STATE_DIVERGED,
+		"missing":    SYNC_STATE_MISSING,
+		"no-remote":  SYNC_STATE_NO_REMOTE,
+		"untracked":  SYNC_STATE_UNTRACKED,
+		"unverified": SYNC_STATE_UNVERIFIED,
+	}
+
+	// plugins contains a (backend name => executable path) map of all
+	// plugins in PATH, which is filled on first use
+	plugins     map[string]string
+	pluginsOnce sync.Once
+)
+
+func (this *Plugin) Changes() (bool, error) {
+	var changes bool
+	err := this.call("changes", &changes)
+	return changes, err
+}
+
+func (this *Plugin) Remotes() ([]string, error) {
+	remotes := []string{}
+	err := this.call("remotes", &remotes)
+	return remotes, err
+}
+
+func (this *Plugin) Synced() (SyncStateNum, error) {
+	if states, err := this.States(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else {
+		return SyncedStates(states)
+	}
+}
+
+func (this *Plugin) States() ([]*SyncState, error) {
+	list := []*pluginState{}
+	if err := this.call("states", &list); err != nil {
+		return nil, err
+	}
+	states := make([]*SyncState, len(list))
+	for i, s := range list {
+		state, ok := pluginStates[s.State]
+		if !ok {
+			return nil, fmt.Errorf("Backend %s returned unsupported state \"%s\"", this.backend, s.State)
+		}
+		states[i] = &SyncState{
+			Remote: s.Remote,
+			Branch: s.Branch,
+			State:  state,
+			Ahead:  s.Ahead,
+			Behind: s.Behind,
+		}
+		if s.Error != "" {
+			states[i].Error = fmt.Errorf("%s", s.Error)
+		}
+	}
+	return states, nil
+}
+
+func (this *Plugin) WithContext(ctx context.Context) Repo {
+	plugin := *this
+	plugin.ctx = ctx
+	return &plugin
+}
+
+func (this *Plugin) Type() string {
+	return this.backend
+}
+
+func (this *Plugin) Updates() (bool, error) {
+	var updates bool
+	err := this.call("updates", &updates)
+	return updates, err
+}
+
+// call runs the plugin command and decodes the result
+func (this *Plugin) call(command string, result interface{}) error {
+	return callPlugin(this.ctx, this.command, command, this.path, this.name, result)
+}
+
+// callPlugin runs plugin executable with JSON request on STDIN and decodes the
+// result of the JSON response from STDOUT. The plugin is killed, when the
+// context is done.
+func callPlugin(ctx context.Context, executable, command, path, name string, result interface{}) error {
+	Debug(DEBUG2, "Plugin exec [%s: %s]: %s %s", name, path, executable, command)
+	req, err := json.Marshal(&pluginRequest{
+		Command: command,
+		Path:    path,
+		Name:    name,
+	})
+	if err != nil {
+		return err
+	}
+	parent, ctx, cancel := commandContext(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, executable)
+	killGroup(cmd)
+	cmd.Dir = path
+	cmd.Stdin = bytes.NewReader(req)
+	errOut := bytes.NewBuffer(nil)
+	stdOut := bytes.NewBuffer(nil)
+	cmd.Stderr = errOut
+	cmd.Stdout = stdOut
+	err = cmd.Run()
+	if ctxErr := contextError(parent, ctx, filepath.Base(executable)); ctxErr != nil {
+		return ctxErr
+	} else if err != nil {
+		if msg := strings.TrimSpace(errOut.String()); msg != "" {
+			return &execError{err, msg}
+		}
+		return err
+	}
+	Debug(DEBUG3, " out: %s", stdOut.String())
+	res := &pluginResponse{}
+	if err := json.Unmarshal(stdOut.Bytes(), res); err != nil {
+		return fmt.Errorf("Invalid response of %s for %s: %s", filepath.Base(executable), command, err)
+	} else if res.Error != "" {
+		return fmt.Errorf("%s", res.Error)
+	} else if err := json.Unmarshal(res.Result, result); err != nil {
+		return fmt.Errorf("Invalid result of %s for %s: %s", filepath.Base(executable), command, err)
+	}
+	return nil
+}
+
+// findPlugins returns (backend name => executable path) map of all plugins in
+// PATH. Earlier directories in PATH take precedence.
+func findPlugins() map[string]string {
+	found := make(map[string]string)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := file.Name()
+			if file.IsDir() || strings.Index(name, pluginPrefix) != 0 {
+				continue
+			} else if file.Mode()&0111 == 0 && filepath.Ext(name) != ".exe" {
+				continue
+			}
+			backend := strings.TrimSuffix(name[len(pluginPrefix):], ".exe")
+			if _, ok := found[backend]; !ok && backend != "" {
+				Debug(DEBUG3, "Found backend plugin %s in %s", backend, dir)
+				found[backend] = filepath.Join(dir, name)
+			}
+		}
+	}
+	return found
+}
+
+func init() {
+
+	// lowest priority, so that built-in implementations are checked first
+	addWatch(-10, func(entry *Entry, name string) (Repo, error) {
+		pluginsOnce.Do(func() {
+			plugins = findPlugins()
+		})
+		backends := make([]string, 0)
+		for backend := range plugins {
+			backends = append(backends, backend)
+		}
+		sort.Strings(backends)
+		for _, backend := range backends {
+			var detected bool
+			if err := callPlugin(nil, plugins[backend], "detect", entry.Path, name, &detected); err != nil {
+				Debug(DEBUG1, "Backend plugin %s failed to detect %s: %s", backend, entry.Path, err)
+			} else if detected {
+				return &Plugin{
+					name:    name,
+					path:    entry.Path,
+					backend: backend,
+					command: plugins[backend],
+				}, nil
+			}
+		}
+		return nil, nil
+	})
+}
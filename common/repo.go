@@ -1,6 +1,12 @@
 package common
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
 
 // Watch is a repository of a certain kind
 type (
@@ -25,14 +31,168 @@ type (
 		Updates() (bool, error)
 	}
 
+	// Annexed is implemented by repos, which can keep file content outside of
+	// the versioned history (eg git-annex)
+	Annexed interface {
+
+		// Annex returns state of the annexed content or nil, if the repo does
+		// not use an annex
+		Annex() (*AnnexState, error)
+	}
+
+	// AnnexState describes annexed content, which is not (yet) backed up
+	AnnexState struct {
+
+		// Lacking contains files with fewer copies than required (numcopies)
+		Lacking []string
+
+		// Unsynced is whether the annex branch contains changes not synced
+		// with any remote
+		Unsynced bool
+	}
+
+	// Worktrees is implemented by repos, which can have multiple working trees
+	// (eg linked git worktrees)
+	Worktrees interface {
+
+		// Worktrees returns paths of all other working trees of the repo
+		Worktrees() ([]string, error)
+	}
+
+	// Submoduled is implemented by repos, which can contain other repos (eg
+	// git submodules)
+	Submoduled interface {
+
+		// Submodules returns state of all (nested) initialized submodules
+		Submodules() ([]*Submodule, error)
+	}
+
+	// Submodule describes state of a single submodule
+	Submodule struct {
+
+		// Path is relative to the top-most repo
+		Path string
+
+		// Commit is what the parent repo references
+		Commit string
+
+		// Dirty is whether the submodule has uncommitted changes
+		Dirty bool
+
+		// Unpushed is whether the referenced commit is not contained in any
+		// remote
+		Unpushed bool
+
+		// Error of checking the submodule
+		Error error
+	}
+
+	// Contexted is implemented by repos, which kill their running commands
+	// when a context is done
+	Contexted interface {
+
+		// WithContext returns a copy of the repo bound to the context
+		WithContext(ctx context.Context) Repo
+	}
+
+	// Fetched is implemented by repos, which cache the state of their remotes
+	// (eg git remote-tracking branches)
+	Fetched interface {
+
+		// LastFetch returns when the remotes were fetched last, which is the
+		// zero time if they never were
+		LastFetch() (time.Time, error)
+	}
+
+	// Unbacked is implemented by repos, which can have commits existing only
+	// locally, independent of the branches on the remotes
+	Unbacked interface {
+
+		// Unbacked returns amount of commits, which are reachable from local
+		// refs but not from any remote, and the local branches containing them
+		Unbacked() (int, []string, error)
+	}
+
+	// Stashed is implemented by repos, which can set changes aside without
+	// committing them (eg git stash)
+	Stashed interface {
+
+		// Stashes returns all stashed changes, latest first
+		Stashes() ([]*Stash, error)
+	}
+
+	// Stash describes a single set of stashed changes
+	Stash struct {
+
+		// Name references the stash, eg "stash@{0}"
+		Name string
+
+		// Message describes the stash, eg "WIP on master: 1234567 Fix typo"
+		Message string
+
+		// Time is when the changes were stashed
+		Time time.Time
+	}
+
+	// Headed is implemented by repos, which can be left in the middle of an
+	// operation or with a HEAD detached from any branch
+	Headed interface {
+
+		// Head returns the state of the checked out HEAD
+		Head() (*HeadState, error)
+	}
+
+	// HeadState describes the checked out HEAD
+	HeadState struct {
+
+		// Operation is the name of the unfinished operation, eg "rebase", or
+		// empty
+		Operation string
+
+		// Detached is whether HEAD does not point to a branch
+		Detached bool
+
+		// Unreachable is the amount of commits of a detached HEAD, which are
+		// not reachable from any local or remote branch or tag
+		Unreachable int
+	}
+
 	// SyncState describes state of a single (remote) branch compared to local
 	SyncState struct {
 		Remote string
 		Branch string
 		State  SyncStateNum
 		Error  error
+
+		// Upstream is the name of the branch on the remote, if it differs
+		// from the local name
+		Upstream string
+
+		// Tag is the name of the compared local tag, instead of a branch
+		Tag string
+
+		// Ahead is the amount of local commits, which are not in remote
+		Ahead int
+
+		// Behind is the amount of remote commits, which are not in local
+		Behind int
 	}
 	SyncStateNum int
+
+	// RemoteError is returned, if a remote is not accessible, so that local
+	// branches can not be compared with it
+	RemoteError struct {
+		Remote string
+		Reason RemoteFailure
+		Err    error
+	}
+	RemoteFailure int
+
+	// watch is a registered constructor of a specific watch implementation
+	watch struct {
+		priority  int
+		construct func(entry *Entry, name string) (Repo, error)
+	}
 )
 
 const (
@@ -48,23 +208,193 @@ const (
 	// local repo is ahead of remote (we need to push)
 	SYNC_STATE_AHEAD
 
-	// branch exists locally but not on remote
+	// local repo and remote both have commits the other has not (we need to
+	// pull and push)
+	SYNC_STATE_DIVERGED
+
+	// branch exists locally but not on remote, eg the tracked remote branch
+	// has been deleted
 	SYNC_STATE_MISSING
+
+	// local repo has commits but no remote at all (no backup)
+	SYNC_STATE_NO_REMOTE
+
+	// branch does not track any remote branch and does not exist on any
+	// remote (never published)
+	SYNC_STATE_UNTRACKED
+
+	// remote is not accessible (see RemoteError), so that branch could not
+	// be compared
+	SYNC_STATE_UNVERIFIED
+
+	// local tag does not exist on remote (we need to push tags)
+	SYNC_STATE_TAG_MISSING
+
+	// local tag points to another object than the equally named tag of
+	// the remote
+	SYNC_STATE_TAG_DIFFERENT
+
+	// local repo is ahead of a remote, which can not be pushed to (we need
+	// to fork)
+	SYNC_STATE_AHEAD_READ_ONLY
+)
+
+const (
+	REMOTE_AUTH_FAILED RemoteFailure = iota + 1
+	REMOTE_UNREACHABLE
+	REMOTE_NOT_FOUND
+)
+
+var (
+	// NoFetch disables fetching remotes. Repos are compared with the remote
+	// state of their last fetch instead, if they have one.
+	NoFetch bool
+
+	// FetchTTL is the duration after the last fetch, in which remotes are not
+	// fetched again
+	FetchTTL time.Duration
+
+	// ErrOffline is returned by repos, which need to contact the remote to
+	// compare with it, if NoFetch is set
+	ErrOffline = errors.New("Remote can not be checked without fetching")
 )
 
-// watches holds checkers/constructors of specific watch implementations
-var watches = make([]func(path, name string) (Repo, error), 0)
+// syncedPrecedence orders states by relevance, when states of multiple
+// branches are reduced into one
+var syncedPrecedence = []SyncStateNum{
+	SYNC_STATE_NO_REMOTE,
+	SYNC_STATE_DIVERGED,
+	SYNC_STATE_AHEAD,
+	SYNC_STATE_AHEAD_READ_ONLY,
+	SYNC_STATE_TAG_DIFFERENT,
+	SYNC_STATE_TAG_MISSING,
+	SYNC_STATE_UNTRACKED,
+	SYNC_STATE_MISSING,
+	SYNC_STATE_BEHIND,
+	SYNC_STATE_UNVERIFIED,
+}
+
+// String describes the state, including the commit counts
+func (this *SyncState) String() string {
+	switch this.State {
+	case SYNC_STATE_FAIL:
+		if this.Error != nil {
+			return this.Error.Error()
+		}
+		return "failed"
+	case SYNC_STATE_SAME:
+		return "in sync"
+	case SYNC_STATE_BEHIND:
+		if this.Behind == 0 {
+			return "behind"
+		}
+		return fmt.Sprintf("%d behind", this.Behind)
+	case SYNC_STATE_AHEAD:
+		return fmt.Sprintf("%d ahead", this.Ahead)
+	case SYNC_STATE_DIVERGED:
+		return fmt.Sprintf("%d ahead, %d behind", this.Ahead, this.Behind)
+	case SYNC_STATE_AHEAD_READ_ONLY:
+		if this.Behind > 0 {
+			return fmt.Sprintf("%d ahead, %d behind of read-only remote", this.Ahead, this.Behind)
+		}
+		return fmt.Sprintf("%d ahead of read-only remote", this.Ahead)
+	case SYNC_STATE_MISSING, SYNC_STATE_TAG_MISSING:
+		return "missing on remote"
+	case SYNC_STATE_TAG_DIFFERENT:
+		return "differs from remote"
+	case SYNC_STATE_NO_REMOTE:
+		return "no remote"
+	case SYNC_STATE_UNTRACKED:
+		return "never published"
+	case SYNC_STATE_UNVERIFIED:
+		if this.Error != nil {
+			return this.Error.Error()
+		}
+		return "not verified"
+	}
+	return "unknown"
+}
+
+func (this *RemoteError) Error() string {
+	return fmt.Sprintf("Remote %s: %s", this.Remote, this.Reason)
+}
+
+func (this RemoteFailure) String() string {
+	switch this {
+	case REMOTE_AUTH_FAILED:
+		return "authentication failed"
+	case REMOTE_UNREACHABLE:
+		return "host unreachable"
+	case REMOTE_NOT_FOUND:
+		return "repository not found"
+	}
+	return "unknown failure"
+}
+
+// SyncedStates reduces states of all branches into the most relevant state of
+// the whole repo. The first failed state is returned as error.
+func SyncedStates(states []*SyncState) (SyncStateNum, error) {
+	found := make(map[SyncStateNum]bool)
+	for _, state := range states {
+		if state.State == SYNC_STATE_FAIL {
+			return SYNC_STATE_FAIL, state.Error
+		}
+		found[state.State] = true
+	}
+	for _, state := range syncedPrecedence {
+		if found[state] {
+			return state, nil
+		}
+	}
+	return SYNC_STATE_SAME, nil
+}
+
+// aheadBehindState returns the state of a branch from its commit counts
+func aheadBehindState(ahead, behind int) SyncStateNum {
+	if ahead > 0 && behind > 0 {
+		return SYNC_STATE_DIVERGED
+	} else if ahead > 0 {
+		return SYNC_STATE_AHEAD
+	} else if behind > 0 {
+		return SYNC_STATE_BEHIND
+	} else {
+		return SYNC_STATE_SAME
+	}
+}
+
+// watches holds checkers/constructors of specific watch implementations, the
+// highest priority first
+var watches = make([]*watch, 0)
+
+// addWatch registers constructor of a watch implementation. Constructors with
+// higher priority are checked first, so that they can claim directories which
+// other implementations would accept as well (eg colocated repos).
+func addWatch(priority int, construct func(entry *Entry, name string) (Repo, error)) {
+	watches = append(watches, &watch{
+		priority:  priority,
+		construct: construct,
+	})
+	sort.SliceStable(watches, func(i, j int) bool {
+		return watches[i].priority > watches[j].priority
+	})
+}
 
 // NewWatch tries to create a new watch from given path. If there is no repo
 // found under path (or there is no implementation for the repo kind) an error
 // is returned
 func NewRepo(path, name string) (Repo, error) {
+	return NewRepoFromEntry(&Entry{Path: path}, name)
+}
+
+// NewRepoFromEntry tries to create a new watch from given registry entry. See
+// NewRepo
+func NewRepoFromEntry(entry *Entry, name string) (Repo, error) {
 	for _, check := range watches {
-		if watch, err := check(path, name); err != nil {
+		if watch, err := check.construct(entry, name); err != nil {
 			return nil, err
 		} else if watch != nil {
 			return watch, nil
 		}
 	}
-	return nil, fmt.Errorf("No implementation found to watch %s", path)
+	return nil, fmt.Errorf("No implementation found to watch %s", entry.Path)
 }
@@ -0,0 +1,202 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Svn is a watch of a Subversion working copy
+type Svn struct {
+	name string
+	path string
+	ctx  context.Context
+}
+
+func (this *Svn) Changes() (bool, error) {
+	if lines, err := this.exec("status", "--ignore-externals"); err != nil {
+		return false, err
+	} else {
+		for _, line := range lines {
+			if line[0] != 'X' {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// ChangeSet returns the changes of the working copy by the first columns of
+// the status. Added files are considered staged, since they are scheduled for
+// the next commit.
+func (this *Svn) ChangeSet() (*ChangeSet, error) {
+	changes := &ChangeSet{}
+	if lines, err := this.exec("status", "--ignore-externals"); err != nil {
+		return nil, err
+	} else {
+		for _, line := range lines {
+			// skip descriptions of tree conflicts and externals
+			if len(line) < 9 || line[0] == 'X' || line[6] == '>' {
+				continue
+			}
+			file := filepath.ToSlash(line[8:])
+			if line[0] == 'C' || line[1] == 'C' || line[6] == 'C' {
+				changes.Conflicted = append(changes.Conflicted, file)
+			} else if line[0] == 'A' {
+				changes.Staged = append(changes.Staged, file)
+			} else if line[0] == 'D' || line[0] == '!' {
+				changes.Deleted = append(changes.Deleted, file)
+			} else if line[0] == '?' {
+				changes.Untracked = append(changes.Untracked, file)
+			} else if line[0] == 'M' || line[0] == 'R' || line[0] == '~' || line[1] == 'M' {
+				changes.Modified = append(changes.Modified, file)
+			}
+		}
+		changes.sort()
+		return changes, nil
+	}
+}
+
+func (this *Svn) Remotes() ([]string, error) {
+	if root, err := this.info("repos-root-url"); err != nil {
+		return nil, err
+	} else {
+		return []string{root}, nil
+	}
+}
+
+func (this *Svn) Synced() (SyncStateNum, error) {
+	if updates, err := this.Updates(); err != nil {
+		return SYNC_STATE_FAIL, err
+	} else if updates {
+		return SYNC_STATE_BEHIND, nil
+	} else {
+		return SYNC_STATE_SAME, nil
+	}
+}
+
+func (this *Svn) States() ([]*SyncState, error) {
+	if root, err := this.info("repos-root-url"); err != nil {
+		return nil, err
+	} else if branch, err := this.info("relative-url"); err != nil {
+		return nil, err
+	} else {
+		state := &SyncState{
+			Remote: root,
+			Branch: branch,
+		}
+		if updates, err := this.Updates(); err != nil {
+			state.State = SYNC_STATE_FAIL
+			state.Error = err
+		} else if !updates {
+			state.State = SYNC_STATE_SAME
+		} else if state.Behind, err = this.behind(); err != nil {
+			state.State = SYNC_STATE_FAIL
+			state.Error = err
+		} else {
+			state.State = SYNC_STATE_BEHIND
+		}
+		return []*SyncState{state}, nil
+	}
+}
+
+func (this *Svn) WithContext(ctx context.Context) Repo {
+	svn := *this
+	svn.ctx = ctx
+	return &svn
+}
+
+func (this *Svn) Type() string {
+	return "Svn"
+}
+
+// Updates checks whether the working copy is older than HEAD in the repository
+func (this *Svn) Updates() (bool, error) {
+	var lines []string
+	if NoFetch {
+		return false, ErrOffline
+	} else if root, err := this.info("repos-root-url"); err != nil {
+		return false, err
+	} else if err := withHost(this.ctx, root, func() (err error) {
+		lines, err = this.exec("status", "--show-updates", "--quiet", "--ignore-externals")
+		return
+	}); err != nil {
+		return false, err
+	} else {
+		// 9th column marks items which have a newer revision on the server
+		for _, line := range lines {
+			if len(line) > 8 && line[8] == '*' {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// behind returns the amount of revisions in the repository, which changed the
+// working copy after its revision
+func (this *Svn) behind() (int, error) {
+	var lines []string
+	if base, err := this.info("revision"); err != nil {
+		return 0, err
+	} else if revision, err := strconv.Atoi(base); err != nil {
+		return 0, fmt.Errorf("Invalid revision \"%s\": %s", base, err)
+	} else if url, err := this.info("url"); err != nil {
+		return 0, err
+	} else if err := withHost(this.ctx, url, func() (err error) {
+		lines, err = this.exec("log", "--quiet", "--revision", "HEAD:"+base, url)
+		return
+	}); err != nil {
+		return 0, err
+	} else {
+		rx := regexp.MustCompile(`^r(\d+) \|`)
+		count := 0
+		for _, line := range lines {
+			if m := rx.FindStringSubmatch(line); m == nil {
+				continue
+			} else if r, _ := strconv.Atoi(m[1]); r > revision {
+				count++
+			}
+		}
+		return count, nil
+	}
+}
+
+// info returns a single item of the working copy info
+func (this *Svn) info(item string) (string, error) {
+	if lines, err := this.exec("info", "--show-item", item); err != nil {
+		return "", err
+	} else if len(lines) == 0 {
+		return "", fmt.Errorf("No %s found in info", item)
+	} else {
+		return lines[0], nil
+	}
+}
+
+// exec runs svn command and returns the lines printed to STDOUT
+func (this *Svn) exec(args ...string) ([]string, error) {
+	return execLines(this.ctx, "svn", this.name, this.path, nil, append([]string{"--non-interactive"}, args...)...)
+}
+
+func init() {
+	addWatch(0, func(entry *Entry, name string) (Repo, error) {
+		svn := filepath.Join(entry.Path, ".svn")
+		if stat, err := os.Stat(svn); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			} else {
+				return nil, err
+			}
+		} else if !stat.IsDir() {
+			return nil, fmt.Errorf("Found \".svn\" in \"%s\", but it is not a directory", entry.Path)
+		} else {
+			return &Svn{
+				path: entry.Path,
+				name: name,
+			}, nil
+		}
+	})
+}
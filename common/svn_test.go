@@ -0,0 +1,85 @@
+package common
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSvn(t *testing.T) {
+	for _, command := range []string{"svn", "svnadmin"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s is not installed", command)
+		}
+	}
+	dir := t.TempDir()
+	run := func(command string, args ...string) {
+		cmd := exec.Command(command, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s %v: %s %s", command, args, err, out)
+		}
+	}
+	url := "file://" + filepath.ToSlash(filepath.Join(dir, "repo"))
+	if url[len("file://")] != '/' {
+		url = "file:///" + url[len("file://"):]
+	}
+	run("svnadmin", "create", "repo")
+	run("svn", "checkout", "--quiet", url, "wc")
+	run("svn", "checkout", "--quiet", url, "other")
+
+	repo, err := NewRepo(filepath.Join(dir, "wc"), "wc")
+	if err != nil {
+		t.Fatal(err)
+	} else if repo.Type() != "Svn" {
+		t.Fatalf("Expected Svn, got %s", repo.Type())
+	}
+	if remotes, err := repo.Remotes(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(remotes, []string{url}) {
+		t.Errorf("Expected remotes %v, got %v", []string{url}, remotes)
+	}
+	if changes, err := repo.Changes(); err != nil {
+		t.Fatal(err)
+	} else if changes {
+		t.Error("Expected no changes in fresh working copy")
+	} else if synced, err := repo.Synced(); err != nil {
+		t.Fatal(err)
+	} else if synced != SYNC_STATE_SAME {
+		t.Errorf("Expected fresh working copy in sync, got %d", synced)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "wc", "new"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	} else if changes, err := repo.Changes(); err != nil {
+		t.Fatal(err)
+	} else if !changes {
+		t.Error("Expected untracked file as change")
+	} else if set, err := repo.(Changed).ChangeSet(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(set.Untracked, []string{"new"}) {
+		t.Errorf("Expected untracked file, got %+v", set)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "other", "file"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("svn", "add", "--quiet", filepath.Join("other", "file"))
+	run("svn", "commit", "--quiet", "-m", "add file", "other")
+	if updates, err := repo.Updates(); err != nil {
+		t.Fatal(err)
+	} else if !updates {
+		t.Error("Expected updates after commit in other working copy")
+	} else if synced, err := repo.Synced(); err != nil {
+		t.Fatal(err)
+	} else if synced != SYNC_STATE_BEHIND {
+		t.Errorf("Expected working copy behind, got %d", synced)
+	}
+	if states, err := repo.States(); err != nil {
+		t.Fatal(err)
+	} else if len(states) != 1 || states[0].Behind != 1 {
+		t.Errorf("Expected working copy 1 behind, got %v", states)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"github.com/ukautz/repos/commands"
 	"github.com/ukautz/repos/common/debug"
 	"github.com/ukautz/repos/common"
 	"gopkg.in/ukautz/clif.v1"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -41,7 +43,39 @@ func addDefaultOptions(cli *clif.Cli) {
 		}
 		return value, nil
 	})
-	cli.AddDefaultOptions(storeOpt, verboseOpt)
+	gitBackendOpt := clif.NewOption("git-backend", "g", "Implementation of git repos: \"exec\" runs git for each operation, \"native\" reads repos in-process", common.GIT_BACKEND_EXEC, false, false).
+		SetEnv("REPOS_GIT_BACKEND").
+		SetParse(func(name, value string) (string, error) {
+		if value != common.GIT_BACKEND_EXEC && value != common.GIT_BACKEND_NATIVE {
+			return value, fmt.Errorf("Unsupported git backend \"%s\"", value)
+		}
+		common.GitBackend = value
+		return value, nil
+	})
+	configDefault := filepath.Join(os.Getenv("HOME"), ".repos.config.json")
+	configOpt := clif.NewOption("config", "C", "Path JSON file with settings, eg rules of read-only remotes", configDefault, true, false).
+		SetEnv("REPOS_CONFIG").
+		SetParse(func(name, value string) (string, error) {
+		if config, err := common.ReadConfig(value); err != nil {
+			return value, err
+		} else {
+			common.ReadOnlyRemotes = append(common.ReadOnlyRemotes, config.ReadOnly...)
+			return value, nil
+		}
+	})
+	commandTimeoutOpt := clif.NewOption("command-timeout", "c", "Maximum duration of a single command run on a repo (eg git fetch), eg 30s", "", false, false).
+		SetEnv("REPOS_COMMAND_TIMEOUT").
+		SetParse(func(name, value string) (string, error) {
+		if value == "" {
+			return value, nil
+		} else if timeout, err := time.ParseDuration(value); err != nil {
+			return value, fmt.Errorf("Invalid command timeout \"%s\": %s", value, err)
+		} else {
+			common.CommandTimeout = timeout
+			return value, nil
+		}
+	})
+	cli.AddDefaultOptions(storeOpt, verboseOpt, gitBackendOpt, configOpt, commandTimeoutOpt)
 }
 
 func main() {